@@ -1,51 +1,319 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"regexp"
+	"time"
 
 	"github.com/ZipRecruiter/cloudwatching/pkg/exportcloudwatch"
+	"github.com/aws/aws-sdk-go/aws/arn"
 )
 
 type exportConfig struct {
 	Namespace, Name string
 
+	NameDerivationVersion uint
+	PrometheusName        string
+	Prefix                string
+	LabelNames            map[string]string
+
+	ConstLabels    map[string]string
+	LabelTemplates []labelTemplate
+
+	TagLabels    map[string]string
+	ResourceInfo bool
+
+	NameMatch, NameNoMatch string
+
 	Dimensions, Statistics []string
 
+	OptionalDimensions []string
+	ExtraDimensions    bool
+
+	AggregateBy []string
+	Aggregation string
+
+	QuantileLabel, Summary, Counter bool
+
+	Unit string
+
 	DimensionsMatch, DimensionsNoMatch map[string]string
 
+	TagsMatch, TagsNoMatch map[string]string
+
 	StatDefault string
+
+	Regions, Accounts []string
+
+	GracePeriod duration
+
+	Period, Range duration
+	Delay         *duration
+
+	IncludeIncomplete bool
+
+	Timestamps bool
+
+	Search bool
+}
+
+type labelTemplate struct {
+	Name, Dimension, Regexp, Template string
+}
+
+type expressionMetric struct {
+	Namespace, Name, Statistic string
+
+	Dimensions map[string]string
+}
+
+// expressionConfig describes a Metric Math expression to export
+type expressionConfig struct {
+	Name, Help string
+	Labels     map[string]string
+
+	Expression string
+	Metrics    map[string]expressionMetric
+
+	StatDefault string
+
+	Regions, Accounts []string
+
+	Period, Range duration
+	Delay         *duration
+
+	IncludeIncomplete bool
+
+	Timestamps bool
+}
+
+// accountConfig describes a role to assume to read the metrics of another
+// account
+type accountConfig struct {
+	RoleARN, ExternalID, SessionName string
+
+	accountID string
+}
+
+// duration is a time.Duration that is written as a string like "5m" in JSON
+type duration struct {
+	time.Duration
+}
+
+// value returns the time.Duration of d, or nil if d wasn't set
+func (d *duration) value() *time.Duration {
+	if d == nil {
+		return nil
+	}
+
+	return &d.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
 }
 
 type configuration struct {
-	Region string
-	Debug  bool
+	Region  string
+	Regions []string
+	Debug   bool
+
+	// PollInterval is how often metrics are read from CloudWatch
+	PollInterval duration
+
+	// Concurrency is how many GetMetricData batches are read at a time
+	Concurrency int
+
+	// RateLimits are the maximum calls per second to CloudWatch APIs, by name
+	// (like GetMetricData or ListMetrics,) per account and region
+	RateLimits map[string]float64
+
+	// MaxRetries is how many times throttled or failed calls are retried (5
+	// if unset); 0 disables retries
+	MaxRetries *int
+
+	// TagCacheTTL is how long the tags of resources are cached
+	TagCacheTTL duration
+
+	Accounts []accountConfig
 
 	ExportConfigs []exportConfig
+	Expressions   []expressionConfig
+
+	exportConfigs     []exportcloudwatch.ExportConfig
+	expressionConfigs []exportcloudwatch.ExpressionConfig
+}
 
-	exportConfigs []exportcloudwatch.ExportConfig
+func parseStatDefault(s string) (exportcloudwatch.StatDefaultType, error) {
+	switch s {
+	case "", "Prior":
+		return exportcloudwatch.Prior, nil
+	case "Zero":
+		return exportcloudwatch.Zero, nil
+	case "NaN":
+		return exportcloudwatch.NaN, nil
+	default:
+		return 0, errors.New("StatDefault must be one of Prior, Zero, or NaN")
+	}
+}
+
+// checkScope returns an error if regions or accounts aren't in the configured
+// sets
+func checkScope(regions, accounts []string, configuredRegions, configuredAccounts map[string]bool) error {
+	for _, r := range regions {
+		if !configuredRegions[r] {
+			return errors.New("ExportConfig region " + r + " is not listed in Regions")
+		}
+	}
+
+	for _, a := range accounts {
+		if !configuredAccounts[a] {
+			return errors.New("ExportConfig account " + a + " is not listed in Accounts")
+		}
+	}
+
+	return nil
 }
 
 func (c *configuration) Validate() error {
+	if c.PollInterval.Duration == 0 {
+		c.PollInterval.Duration = time.Minute
+	} else if c.PollInterval.Duration < 0 {
+		return errors.New("PollInterval must be positive")
+	}
+
+	if c.Concurrency == 0 {
+		c.Concurrency = 1
+	} else if c.Concurrency < 0 {
+		return errors.New("Concurrency must be positive")
+	}
+
+	for op, rate := range c.RateLimits {
+		if op != "GetMetricData" && op != "ListMetrics" {
+			return errors.New("RateLimits may only be set for GetMetricData and ListMetrics")
+		}
+		if rate <= 0 {
+			return errors.New("RateLimits must be positive")
+		}
+	}
+
+	if c.MaxRetries == nil {
+		maxRetries := 5
+		c.MaxRetries = &maxRetries
+	} else if *c.MaxRetries < 0 {
+		return errors.New("MaxRetries must not be negative")
+	}
+
+	if c.TagCacheTTL.Duration == 0 {
+		c.TagCacheTTL.Duration = 30 * time.Minute
+	} else if c.TagCacheTTL.Duration < 0 {
+		return errors.New("TagCacheTTL must be positive")
+	}
+
+	if c.Region != "" {
+		if len(c.Regions) != 0 {
+			return errors.New("Only one of Region and Regions may be set")
+		}
+		c.Regions = []string{c.Region}
+	}
+
+	seen := make(map[string]bool, len(c.Regions))
+	for _, r := range c.Regions {
+		if seen[r] {
+			return errors.New("Region " + r + " is listed more than once")
+		}
+		seen[r] = true
+	}
+
+	accounts := make(map[string]bool, len(c.Accounts))
+	for i, a := range c.Accounts {
+		parsed, err := arn.Parse(a.RoleARN)
+		if err != nil {
+			return errors.New("Invalid RoleARN " + a.RoleARN + ": " + err.Error())
+		}
+		if accounts[parsed.AccountID] {
+			return errors.New("Account " + parsed.AccountID + " is listed more than once")
+		}
+		accounts[parsed.AccountID] = true
+		c.Accounts[i].accountID = parsed.AccountID
+	}
+
 	c.exportConfigs = make([]exportcloudwatch.ExportConfig, len(c.ExportConfigs))
 	for i, raw := range c.ExportConfigs {
 		c.exportConfigs[i] = exportcloudwatch.ExportConfig{
-			Namespace:         raw.Namespace,
-			Name:              raw.Name,
-			Dimensions:        raw.Dimensions,
-			Statistics:        raw.Statistics,
-			DimensionsMatch:   make(map[string]*regexp.Regexp, len(raw.DimensionsMatch)),
-			DimensionsNoMatch: make(map[string]*regexp.Regexp, len(raw.DimensionsNoMatch)),
+			Namespace:             raw.Namespace,
+			Name:                  raw.Name,
+			NameDerivationVersion: raw.NameDerivationVersion,
+			PrometheusName:        raw.PrometheusName,
+			Prefix:                raw.Prefix,
+			LabelNames:            raw.LabelNames,
+			ConstLabels:           raw.ConstLabels,
+			TagLabels:             raw.TagLabels,
+			ResourceInfo:          raw.ResourceInfo,
+			Dimensions:            raw.Dimensions,
+			OptionalDimensions:    raw.OptionalDimensions,
+			ExtraDimensions:       raw.ExtraDimensions,
+			AggregateBy:           raw.AggregateBy,
+			Aggregation:           raw.Aggregation,
+			Statistics:            raw.Statistics,
+			QuantileLabel:         raw.QuantileLabel,
+			Summary:               raw.Summary,
+			Counter:               raw.Counter,
+			Unit:                  raw.Unit,
+			Regions:               raw.Regions,
+			Accounts:              raw.Accounts,
+			GracePeriod:           raw.GracePeriod.Duration,
+			Period:                raw.Period.Duration,
+			Delay:                 raw.Delay.value(),
+			Range:                 raw.Range.Duration,
+			IncludeIncomplete:     raw.IncludeIncomplete,
+			Timestamps:            raw.Timestamps,
+			Search:                raw.Search,
+			DimensionsMatch:       make(map[string]*regexp.Regexp, len(raw.DimensionsMatch)),
+			DimensionsNoMatch:     make(map[string]*regexp.Regexp, len(raw.DimensionsNoMatch)),
+			TagsMatch:             make(map[string]*regexp.Regexp, len(raw.TagsMatch)),
+			TagsNoMatch:           make(map[string]*regexp.Regexp, len(raw.TagsNoMatch)),
+		}
+
+		var err error
+		if c.exportConfigs[i].StatDefault, err = parseStatDefault(raw.StatDefault); err != nil {
+			return err
+		}
+
+		if err := checkScope(raw.Regions, raw.Accounts, seen, accounts); err != nil {
+			return err
+		}
+
+		if raw.NameMatch != "" {
+			if c.exportConfigs[i].NameMatch, err = regexp.Compile(raw.NameMatch); err != nil {
+				return err
+			}
+		}
+		if raw.NameNoMatch != "" {
+			if c.exportConfigs[i].NameNoMatch, err = regexp.Compile(raw.NameNoMatch); err != nil {
+				return err
+			}
 		}
 
-		if raw.StatDefault == "Prior" {
-			c.exportConfigs[i].StatDefault = exportcloudwatch.Prior
-		} else if raw.StatDefault == "Zero" {
-			c.exportConfigs[i].StatDefault = exportcloudwatch.Zero
-		} else if raw.StatDefault == "NaN" {
-			c.exportConfigs[i].StatDefault = exportcloudwatch.NaN
-		} else if raw.StatDefault != "" {
-			return errors.New("StatDefault must be one of Prior, Zero, or NaN")
+		for _, t := range raw.LabelTemplates {
+			re, err := regexp.Compile(t.Regexp)
+			if err != nil {
+				return err
+			}
+			c.exportConfigs[i].LabelTemplates = append(c.exportConfigs[i].LabelTemplates, exportcloudwatch.LabelTemplate{
+				Name:      t.Name,
+				Dimension: t.Dimension,
+				Regexp:    re,
+				Template:  t.Template,
+			})
 		}
 
 		for k, v := range raw.DimensionsMatch {
@@ -62,10 +330,59 @@ func (c *configuration) Validate() error {
 			}
 			c.exportConfigs[i].DimensionsNoMatch[k] = re
 		}
+		for k, v := range raw.TagsMatch {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return err
+			}
+			c.exportConfigs[i].TagsMatch[k] = re
+		}
+		for k, v := range raw.TagsNoMatch {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return err
+			}
+			c.exportConfigs[i].TagsNoMatch[k] = re
+		}
 		if err := c.exportConfigs[i].Validate(); err != nil {
 			return err
 		}
 	}
 
+	c.expressionConfigs = make([]exportcloudwatch.ExpressionConfig, len(c.Expressions))
+	for i, raw := range c.Expressions {
+		c.expressionConfigs[i] = exportcloudwatch.ExpressionConfig{
+			Name:              raw.Name,
+			Help:              raw.Help,
+			Labels:            raw.Labels,
+			Expression:        raw.Expression,
+			Metrics:           make(map[string]exportcloudwatch.ExpressionMetric, len(raw.Metrics)),
+			Regions:           raw.Regions,
+			Accounts:          raw.Accounts,
+			Period:            raw.Period.Duration,
+			Delay:             raw.Delay.value(),
+			Range:             raw.Range.Duration,
+			IncludeIncomplete: raw.IncludeIncomplete,
+			Timestamps:        raw.Timestamps,
+		}
+
+		for id, m := range raw.Metrics {
+			c.expressionConfigs[i].Metrics[id] = exportcloudwatch.ExpressionMetric(m)
+		}
+
+		var err error
+		if c.expressionConfigs[i].StatDefault, err = parseStatDefault(raw.StatDefault); err != nil {
+			return err
+		}
+
+		if err := checkScope(raw.Regions, raw.Accounts, seen, accounts); err != nil {
+			return err
+		}
+
+		if err := c.expressionConfigs[i].Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountValidation(t *testing.T) {
+	c := configuration{Accounts: []accountConfig{{RoleARN: "cloudwatching"}}}
+	assert.Error(t, c.Validate())
+
+	c = configuration{
+		Accounts: []accountConfig{
+			{RoleARN: "arn:aws:iam::111111111111:role/a"},
+			{RoleARN: "arn:aws:iam::111111111111:role/b"},
+		},
+	}
+	assert.EqualError(t, c.Validate(), "Account 111111111111 is listed more than once")
+
+	c = configuration{
+		Accounts: []accountConfig{{RoleARN: "arn:aws:iam::111111111111:role/a"}},
+		ExportConfigs: []exportConfig{{
+			Namespace:  "AWS/SQS",
+			Name:       "NumberOfMessagesSent",
+			Statistics: []string{"Sum"},
+			Accounts:   []string{"222222222222"},
+		}},
+	}
+	assert.EqualError(t, c.Validate(), "ExportConfig account 222222222222 is not listed in Accounts")
+}
+
+func TestMaxRetriesValidation(t *testing.T) {
+	var c configuration
+	assert.NoError(t, json.Unmarshal([]byte(`{}`), &c))
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, 5, *c.MaxRetries)
+
+	c = configuration{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"maxRetries": 0}`), &c))
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, 0, *c.MaxRetries, "retries can be disabled")
+
+	c = configuration{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"maxRetries": -1}`), &c))
+	assert.EqualError(t, c.Validate(), "MaxRetries must not be negative")
+}
+
+func TestDelayValidation(t *testing.T) {
+	var c configuration
+	assert.NoError(t, json.Unmarshal([]byte(`{"exportConfigs": [
+		{"namespace": "AWS/SQS", "name": "TestDelayValidationDefault", "statistics": ["Sum"]},
+		{"namespace": "AWS/SQS", "name": "TestDelayValidationZero", "statistics": ["Sum"], "delay": "0s"}
+	]}`), &c))
+	assert.NoError(t, c.Validate())
+
+	assert.Nil(t, c.exportConfigs[0].Delay, "the delay defaults to one period")
+	if assert.NotNil(t, c.exportConfigs[1].Delay) {
+		assert.Equal(t, time.Duration(0), *c.exportConfigs[1].Delay)
+	}
+}
@@ -4,10 +4,17 @@ import (
 	"log"
 	"time"
 
+	"github.com/ZipRecruiter/cloudwatching/pkg/exportcloudwatch"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -19,16 +26,32 @@ var awsRequestSeconds = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 var awsErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Name: "monitoring_cloudwatch_aws_errors_total",
 	Help: "Count of errors we've gotten from the AWS service",
-}, []string{"service", "call"})
+}, []string{"service", "call", "code"})
 
 func init() {
 	prometheus.MustRegister(awsRequestSeconds, awsErrorsTotal)
 }
 
-func initDependencies(config configuration) (*cloudwatch.CloudWatch, error) {
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(config.Region),
-	}))
+// errorCode returns the code of an AWS error; the services use several codes
+// for throttling, which are all counted as Throttling.
+func errorCode(err error) string {
+	if request.IsErrorThrottle(err) {
+		return "Throttling"
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+
+	return ""
+}
+
+func initDependencies(config configuration) ([]exportcloudwatch.Client, error) {
+	// throttled requests are retried with jittered exponential backoff
+	sess := session.Must(session.NewSession(request.WithRetryer(aws.NewConfig(), client.DefaultRetryer{
+		NumMaxRetries:    *config.MaxRetries,
+		MinThrottleDelay: time.Second,
+		MaxThrottleDelay: 30 * time.Second,
+	})))
 
 	// XXX recieve session as argument
 	// awssession.ApplyUserAgent(sess, fmt.Sprintf("ZipRecruiter (monitoring/cloudwatch; %s; security@ziprecruiter.com)", Version))
@@ -54,9 +77,103 @@ func initDependencies(config configuration) (*cloudwatch.CloudWatch, error) {
 		Fn: func(r *request.Request) {
 			service := r.ClientInfo.ServiceName
 			call := r.Operation.Name
-			awsErrorsTotal.WithLabelValues(service, call).Inc()
+			awsErrorsTotal.WithLabelValues(service, call, errorCode(r.Error)).Inc()
 		},
 	})
 
-	return cloudwatch.New(sess), nil
+	var clients []exportcloudwatch.Client
+	if len(config.Accounts) == 0 {
+		// the account only labels the metrics, so they are still exported
+		// without it
+		var accountID string
+		gcio, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		if err != nil {
+			log.Printf("sts.GetCallerIdentity: %s; account_id will be empty", err)
+		} else {
+			accountID = aws.StringValue(gcio.Account)
+		}
+
+		clients = newClients(sess, accountID, config.Regions, config.RateLimits)
+	} else {
+		clients = accountClients(sess, config.Accounts, config.Regions, config.RateLimits)
+	}
+
+	// tags are read again only once the cached ones are TagCacheTTL old
+	for i := range clients {
+		clients[i].Tagging = exportcloudwatch.NewCachedTagging(clients[i].Tagging, config.TagCacheTTL.Duration)
+	}
+
+	return clients, nil
+}
+
+// assumeRoleProvider returns the provider of the credentials for an account;
+// tests replace it to avoid calling STS.
+var assumeRoleProvider = func(sess *session.Session, a accountConfig) credentials.Provider {
+	sessionName := a.SessionName
+	if sessionName == "" {
+		sessionName = "cloudwatching"
+	}
+
+	p := &stscreds.AssumeRoleProvider{
+		Client:          sts.New(sess),
+		RoleARN:         a.RoleARN,
+		RoleSessionName: sessionName,
+		Duration:        stscreds.DefaultDuration,
+
+		// refresh the credentials a bit before they expire so that in flight
+		// requests don't fail
+		ExpiryWindow: time.Minute,
+	}
+	if a.ExternalID != "" {
+		p.ExternalID = aws.String(a.ExternalID)
+	}
+
+	return p
+}
+
+// accountClients creates clients for each region of each account, using
+// credentials for the account's role.  The credentials are refreshed as they
+// expire.
+func accountClients(sess *session.Session, accounts []accountConfig, regions []string, rateLimits map[string]float64) []exportcloudwatch.Client {
+	var clients []exportcloudwatch.Client
+	for _, a := range accounts {
+		creds := credentials.NewCredentials(assumeRoleProvider(sess, a))
+		accountSess := sess.Copy(&aws.Config{Credentials: creds})
+		clients = append(clients, newClients(accountSess, a.accountID, regions, rateLimits)...)
+	}
+
+	return clients
+}
+
+// newClients creates a client per region; if no regions are passed a single
+// client is created for the region the session defaults to.  Since AWS limits
+// the rate of calls per account and region, each client has its own rate
+// limits.
+func newClients(sess *session.Session, accountID string, regions []string, rateLimits map[string]float64) []exportcloudwatch.Client {
+	if len(regions) == 0 {
+		return []exportcloudwatch.Client{
+			newClient(sess, accountID, aws.StringValue(sess.Config.Region), rateLimits),
+		}
+	}
+
+	clients := make([]exportcloudwatch.Client, len(regions))
+	for i, r := range regions {
+		clients[i] = newClient(sess, accountID, r, rateLimits)
+	}
+
+	return clients
+}
+
+func newClient(sess *session.Session, accountID, region string, rateLimits map[string]float64) exportcloudwatch.Client {
+	cw := cloudwatch.New(sess, aws.NewConfig().WithRegion(region))
+	if len(rateLimits) != 0 {
+		cw.Handlers.Sign.PushFrontNamed(rateLimitHandler(rateLimits))
+	}
+
+	return exportcloudwatch.Client{
+		Region:     region,
+		AccountID:  accountID,
+		CloudWatch: cw,
+		Tagging:    resourcegroupstaggingapi.New(sess, aws.NewConfig().WithRegion(region)),
+	}
 }
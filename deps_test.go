@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider hands out credentials named after the role, and expires them
+// after every retrieval so each Get refreshes
+type fakeProvider struct {
+	roleARN   string
+	retrieved int
+}
+
+func (p *fakeProvider) Retrieve() (credentials.Value, error) {
+	p.retrieved++
+	return credentials.Value{
+		AccessKeyID:     p.roleARN,
+		SecretAccessKey: "secret",
+		ProviderName:    "fakeProvider",
+	}, nil
+}
+
+func (p *fakeProvider) IsExpired() bool { return true }
+
+func TestAccountClients(t *testing.T) {
+	providers := map[string]*fakeProvider{}
+	defer func(orig func(*session.Session, accountConfig) credentials.Provider) {
+		assumeRoleProvider = orig
+	}(assumeRoleProvider)
+	assumeRoleProvider = func(sess *session.Session, a accountConfig) credentials.Provider {
+		p := &fakeProvider{roleARN: a.RoleARN}
+		providers[a.RoleARN] = p
+		return p
+	}
+
+	c := configuration{
+		Regions: []string{"us-east-1", "us-west-2"},
+		Accounts: []accountConfig{
+			{RoleARN: "arn:aws:iam::111111111111:role/cloudwatching"},
+			{RoleARN: "arn:aws:iam::222222222222:role/cloudwatching", ExternalID: "x"},
+		},
+	}
+	assert.NoError(t, c.Validate())
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+	clients := accountClients(sess, c.Accounts, c.Regions, c.RateLimits)
+
+	got := make([]string, len(clients))
+	for i, client := range clients {
+		got[i] = client.AccountID + " " + client.Region
+
+		cw := client.CloudWatch.(*cloudwatch.CloudWatch)
+		assert.Equal(t, client.Region, aws.StringValue(cw.Config.Region))
+		tagging := client.Tagging.(*resourcegroupstaggingapi.ResourceGroupsTaggingAPI)
+		assert.Equal(t, client.Region, aws.StringValue(tagging.Config.Region))
+
+		v, err := cw.Config.Credentials.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, "arn:aws:iam::"+client.AccountID+":role/cloudwatching", v.AccessKeyID)
+	}
+	assert.Equal(t, []string{
+		"111111111111 us-east-1",
+		"111111111111 us-west-2",
+		"222222222222 us-east-1",
+		"222222222222 us-west-2",
+	}, got)
+
+	// the clients of an account share credentials, which are refreshed once
+	// they expire
+	assert.Equal(t, 2, providers["arn:aws:iam::111111111111:role/cloudwatching"].retrieved)
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "Throttling", errorCode(awserr.New("ThrottlingException", "Rate exceeded", nil)))
+	assert.Equal(t, "Throttling", errorCode(awserr.New("RequestLimitExceeded", "Request limit exceeded", nil)))
+	assert.Equal(t, "AccessDenied", errorCode(awserr.New("AccessDenied", "denied", nil)))
+	assert.Equal(t, "", errorCode(errors.New("boom")))
+}
@@ -8,34 +8,29 @@ import (
 	"time"
 
 	"github.com/ZipRecruiter/cloudwatching/pkg/exportcloudwatch"
-	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var metrics map[string]exportcloudwatch.MetricStat
+var metrics exportcloudwatch.MetricSet
 
 var listMetricsSleep = prometheus.NewSummary(prometheus.SummaryOpts{
 	Name: "monitoring_cloudwatch_list_metrics_sleep",
 	Help: "Amount of time we are going to sleep between updating our metrics list",
 })
 
-func init() {
-	prometheus.MustRegister(listMetricsSleep)
-}
+var listMetricsErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "monitoring_cloudwatch_list_metrics_errors_total",
+	Help: "Count of failed attempts to update our metrics list",
+})
 
-func handler(c configuration, cw *cloudwatch.CloudWatch, inner http.Handler) http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
-		period := 60 * time.Second
-		start := time.Now().Add(-2 * period).Truncate(time.Minute)
-		if err := exportcloudwatch.ReadMetrics(cw, start, period, metrics); err != nil {
-			rw.WriteHeader(500)
-			log.Print(err)
-			return
-		}
+var listMetricsLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "monitoring_cloudwatch_list_metrics_last_success_timestamp_seconds",
+	Help: "Time our metrics list was last updated",
+})
 
-		inner.ServeHTTP(rw, r)
-	}
+func init() {
+	prometheus.MustRegister(listMetricsSleep, listMetricsErrors, listMetricsLastSuccess)
 }
 
 func sleepRange(got, min, max time.Duration) time.Duration {
@@ -50,6 +45,20 @@ func sleepRange(got, min, max time.Duration) time.Duration {
 	return got
 }
 
+// backoff returns how long to wait after the given number of consecutive
+// failures, doubling from min up to max.
+func backoff(failures int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+
+	return sleepRange(d, min, max)
+}
+
 func main() {
 	path := os.Getenv("MC_CONFIG")
 	if path == "" {
@@ -70,7 +79,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	cw, err := initDependencies(c)
+	clients, err := initDependencies(c)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -78,30 +87,45 @@ func main() {
 	var listMetricsDuration time.Duration
 
 	start := time.Now()
-	metrics, err = exportcloudwatch.MetricsToRead(c.exportConfigs, cw)
+	ms, err := exportcloudwatch.MetricsToRead(c.exportConfigs, c.expressionConfigs, clients)
 	if err != nil {
 		log.Fatal(err)
 	}
+	metrics.Update(ms, time.Now())
+	listMetricsLastSuccess.SetToCurrentTime()
 	listMetricsDuration = time.Now().Sub(start)
 
 	go func() {
+		// on failure the prior metrics are kept and the update is retried
+		var failures int
 		for {
 			duration := sleepRange(10*listMetricsDuration, 5*time.Minute, time.Hour)
+			if failures > 0 {
+				duration = backoff(failures, 10*time.Second, duration)
+			}
 
 			listMetricsSleep.Observe(duration.Seconds())
 			time.Sleep(duration)
 
 			start := time.Now()
-			metrics, err = exportcloudwatch.MetricsToRead(c.exportConfigs, cw)
+			ms, err := exportcloudwatch.MetricsToRead(c.exportConfigs, c.expressionConfigs, clients)
 			if err != nil {
-				log.Fatal(err)
+				failures++
+				listMetricsErrors.Inc()
+				log.Print(err)
+				continue
 			}
+			failures = 0
+			metrics.Update(ms, time.Now())
+			listMetricsLastSuccess.SetToCurrentTime()
 			listMetricsDuration = time.Now().Sub(start)
 		}
 	}()
 
+	go poll(c.PollInterval.Duration, func() error { return readMetrics(clients, c.Concurrency) }, nil)
+
 	log.Printf("starting httpserver on :8080")
-	http.Handle("/metrics", handler(c, cw, promhttp.Handler()))
+	http.Handle("/metrics", promhttp.Handler())
 	if err := http.ListenAndServe(":8080", nil); err != nil {
 		log.Fatal(err)
 	}
@@ -43,3 +43,49 @@ func TestSleepRange(t *testing.T) {
 		})
 	}
 }
+
+type testBackoff struct {
+	name     string
+	failures int
+	min, max time.Duration
+	expect   time.Duration
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []testBackoff{
+		{
+			name:     "first",
+			failures: 1,
+			min:      10 * time.Second,
+			max:      5 * time.Minute,
+			expect:   10 * time.Second,
+		},
+		{
+			name:     "doubled",
+			failures: 3,
+			min:      10 * time.Second,
+			max:      5 * time.Minute,
+			expect:   40 * time.Second,
+		},
+		{
+			name:     "max",
+			failures: 6,
+			min:      10 * time.Second,
+			max:      5 * time.Minute,
+			expect:   5 * time.Minute,
+		},
+		{
+			name:     "many",
+			failures: 1000,
+			min:      10 * time.Second,
+			max:      5 * time.Minute,
+			expect:   5 * time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, backoff(test.failures, test.min, test.max))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package exportcloudwatch
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// maxAggregated is how many metrics one aggregate can read, since an
+// aggregate and its inputs must fit in a single GetMetricData call
+const maxAggregated = 499
+
+// aggregations are the Metric Math functions an ExportConfig can aggregate
+// its metrics with
+var aggregations = map[string]bool{
+	"SUM": true,
+	"MAX": true,
+	"MIN": true,
+	"AVG": true,
+}
+
+// aggregated returns true if e exports aggregates of its metrics
+func (e *ExportConfig) aggregated() bool {
+	return len(e.AggregateBy) != 0
+}
+
+// aggregation returns the Metric Math function e aggregates with
+func (e *ExportConfig) aggregation() string {
+	if e.Aggregation == "" {
+		return "SUM"
+	}
+
+	return e.Aggregation
+}
+
+// aggregates groups the MetricStats of an ExportConfig and client by their
+// labels, in the order the groups are found
+type aggregates struct {
+	keys   []string
+	groups map[string][]MetricStat
+}
+
+// add adds the MetricStats of metric to the group called key
+func (a *aggregates) add(e ExportConfig, key string, stats []MetricStat, metric *cloudwatch.Metric) {
+	if a.groups == nil {
+		a.groups = make(map[string][]MetricStat)
+	}
+
+	if group, ok := a.groups[key]; ok {
+		for i := range group {
+			group[i].aggregated = append(group[i].aggregated, metric)
+		}
+		return
+	}
+
+	// the group is named by the dimensions it is aggregated by
+	groupMetric := &cloudwatch.Metric{
+		Namespace:  metric.Namespace,
+		MetricName: metric.MetricName,
+	}
+	for _, d := range e.AggregateBy {
+		if v := dimensionValue(metric, d); v != "" {
+			groupMetric.Dimensions = append(groupMetric.Dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(d),
+				Value: aws.String(v),
+			})
+		}
+	}
+
+	for i := range stats {
+		stats[i].cloudwatchMetric = groupMetric
+		stats[i].aggregation = e.aggregation()
+		stats[i].aggregated = []*cloudwatch.Metric{metric}
+	}
+	a.keys = append(a.keys, key)
+	a.groups[key] = stats
+}
+
+// metricStats returns the MetricStats of the groups read by c; groups of too
+// many metrics are logged and skipped
+func (a *aggregates) metricStats(c Client) []MetricStat {
+	var metrics []MetricStat
+	for _, key := range a.keys {
+		group := a.groups[key]
+		if n := len(group[0].aggregated); n > maxAggregated {
+			log.Printf("account=%s region=%s namespace=%s: skipping the %s aggregate of %v, which has %d metrics (more than %d)", c.AccountID, c.Region, group[0].namespace(), *group[0].cloudwatchMetric.MetricName, group[0].labelValues, n, maxAggregated)
+			continue
+		}
+		metrics = append(metrics, group...)
+	}
+
+	return metrics
+}
+
+// aggregateQueries returns the queries to read m, an aggregate, as id; its
+// inputs are named after id so that they don't collide with other queries
+func (m MetricStat) aggregateQueries(id string, seconds *int64) []*cloudwatch.MetricDataQuery {
+	var unit *string
+	if m.unit != "" {
+		unit = aws.String(m.unit)
+	}
+
+	mdq := make([]*cloudwatch.MetricDataQuery, 0, len(m.aggregated)+1)
+	ids := make([]string, len(m.aggregated))
+	for i, metric := range m.aggregated {
+		ids[i] = id + "_" + strconv.Itoa(i)
+		mdq = append(mdq, &cloudwatch.MetricDataQuery{
+			Id: aws.String(ids[i]),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: metric,
+				Period: seconds,
+				Stat:   aws.String(m.statistic),
+				Unit:   unit,
+			},
+			ReturnData: aws.Bool(false),
+		})
+	}
+
+	return append(mdq, &cloudwatch.MetricDataQuery{
+		Id:         aws.String(id),
+		Expression: aws.String(m.aggregation + "([" + strings.Join(ids, ",") + "])"),
+		Period:     seconds,
+		ReturnData: aws.Bool(true),
+	})
+}
@@ -0,0 +1,151 @@
+package exportcloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsToReadAggregateBy(t *testing.T) {
+	scw := stubCloudWatch{metrics: []*cloudwatch.Metric{
+		newMetric("AWS/RDS", "TestAggregateByConnections", "DBClusterIdentifier", "main", "DBInstanceIdentifier", "main-1"),
+		newMetric("AWS/RDS", "TestAggregateByConnections", "DBClusterIdentifier", "main", "DBInstanceIdentifier", "main-2"),
+		newMetric("AWS/RDS", "TestAggregateByConnections", "DBClusterIdentifier", "reports", "DBInstanceIdentifier", "reports-1"),
+	}}
+
+	e := ExportConfig{
+		Namespace:   "AWS/RDS",
+		Name:        "TestAggregateByConnections",
+		Dimensions:  []string{"DBInstanceIdentifier", "DBClusterIdentifier"},
+		Statistics:  []string{"Sum", "Maximum"},
+		AggregateBy: []string{"DBClusterIdentifier"},
+	}
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, []string{accountLabel, regionLabel, "cluster_identifier"}, e.labels)
+
+	clients := []Client{{Region: "us-east-1", CloudWatch: scw}}
+	ms, err := metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+	if !assert.Len(t, ms, 4) {
+		return
+	}
+
+	assert.Equal(t, []string{"", "us-east-1", "main"}, ms[0].labelValues)
+	assert.Equal(t, "Sum", ms[0].statistic)
+	assert.Equal(t, "Maximum", ms[1].statistic)
+	assert.Len(t, ms[0].aggregated, 2)
+	assert.Equal(t, []string{"", "us-east-1", "reports"}, ms[2].labelValues)
+	assert.Len(t, ms[2].aggregated, 1)
+	assert.Equal(t, "AWS/RDS", ms[0].namespace())
+	assert.Equal(t, []*cloudwatch.Dimension{
+		{Name: aws.String("DBClusterIdentifier"), Value: aws.String("main")},
+	}, ms[0].cloudwatchMetric.Dimensions)
+
+	mdq := ms[0].queries("i3", time.Minute)
+	if !assert.Len(t, mdq, 3) {
+		return
+	}
+	assert.Equal(t, "i3_0", *mdq[0].Id)
+	assert.Equal(t, "main-1", *mdq[0].MetricStat.Metric.Dimensions[1].Value)
+	assert.Equal(t, "Sum", *mdq[0].MetricStat.Stat)
+	assert.Equal(t, int64(60), *mdq[0].MetricStat.Period)
+	assert.False(t, *mdq[0].ReturnData)
+	assert.Equal(t, "i3_1", *mdq[1].Id)
+	assert.Equal(t, "i3", *mdq[2].Id)
+	assert.Equal(t, "SUM([i3_0,i3_1])", *mdq[2].Expression)
+	assert.True(t, *mdq[2].ReturnData)
+
+	// the queries of different aggregates don't collide, so they share a
+	// batch
+	batches := makeBatches(clients, time.Now(), unrollMetrics(ms))
+	if assert.Len(t, batches, 1) {
+		assert.Len(t, batches[0].queries, 10)
+	}
+}
+
+func TestReadMetricsAggregateBy(t *testing.T) {
+	e := ExportConfig{
+		Namespace:   "AWS/ECS",
+		Name:        "TestReadMetricsAggregateBy",
+		Dimensions:  []string{"ClusterName", "ServiceName"},
+		Statistics:  []string{"Maximum"},
+		AggregateBy: []string{"ClusterName"},
+		Aggregation: "MAX",
+	}
+	assert.NoError(t, e.Validate())
+
+	scw := stubCloudWatch{metrics: []*cloudwatch.Metric{
+		newMetric("AWS/ECS", "TestReadMetricsAggregateBy", "ClusterName", "web", "ServiceName", "api"),
+		newMetric("AWS/ECS", "TestReadMetricsAggregateBy", "ClusterName", "web", "ServiceName", "www"),
+	}}
+	clients := []Client{{Region: "us-east-1", CloudWatch: scw}}
+	ms, err := metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+	metricstats := unrollMetrics(ms)
+	if !assert.Len(t, metricstats, 1) {
+		return
+	}
+	assert.Equal(t, "MAX([i0_0,i0_1])", *metricstats["i0"].queries("i0", time.Minute)[2].Expression)
+
+	gauge := &mockGauge{}
+	m := metricstats["i0"]
+	m.gauge = gauge
+	metricstats["i0"] = m
+
+	assert.NoError(t, ReadMetrics(clients, time.Now(), metricstats, 1))
+	if assert.NotNil(t, gauge.value) {
+		assert.Equal(t, 1.0, *gauge.value)
+	}
+}
+
+func TestAggregateByValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		e    ExportConfig
+		err  string
+	}{
+		{
+			name: "not a dimension",
+			e:    ExportConfig{AggregateBy: []string{"TableName"}},
+			err:  "AggregateBy name not in Dimensions",
+		},
+		{
+			name: "without AggregateBy",
+			e:    ExportConfig{Aggregation: "SUM"},
+			err:  "Aggregation requires AggregateBy",
+		},
+		{
+			name: "invalid aggregation",
+			e:    ExportConfig{AggregateBy: []string{"ClusterName"}, Aggregation: "Sum"},
+			err:  "Invalid Aggregation Sum",
+		},
+		{
+			name: "summary",
+			e:    ExportConfig{AggregateBy: []string{"ClusterName"}, Summary: true, Statistics: []string{"SampleCount", "Sum"}},
+			err:  "AggregateBy can't be used with Summary",
+		},
+		{
+			name: "template of a dropped dimension",
+			e: ExportConfig{
+				AggregateBy:    []string{"ClusterName"},
+				LabelTemplates: []LabelTemplate{{Name: "service", Dimension: "ServiceName"}},
+			},
+			err: "LabelTemplates dimension not in Dimensions",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := test.e
+			e.Namespace = "AWS/ECS"
+			e.Name = "CPUUtilization"
+			e.Dimensions = []string{"ClusterName", "ServiceName"}
+			if e.Statistics == nil {
+				e.Statistics = []string{"Maximum"}
+			}
+			assert.EqualError(t, e.Validate(), test.err)
+		})
+	}
+}
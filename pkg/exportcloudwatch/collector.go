@@ -0,0 +1,132 @@
+package exportcloudwatch
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gauge is what the value of a MetricStat is read into
+type gauge interface {
+	Set(float64)
+}
+
+// timestampedGauge is a gauge that also records the time of its value
+type timestampedGauge interface {
+	gauge
+	SetWithTimestamp(float64, time.Time)
+}
+
+// gaugeVec is a collector of gauges partitioned by label values
+type gaugeVec interface {
+	prometheus.Collector
+	with(labelValues ...string) gauge
+	DeleteLabelValues(labelValues ...string) bool
+}
+
+// promGaugeVec is a *prometheus.GaugeVec that satisfies gaugeVec
+type promGaugeVec struct {
+	*prometheus.GaugeVec
+}
+
+func (v promGaugeVec) with(labelValues ...string) gauge {
+	return v.WithLabelValues(labelValues...)
+}
+
+// timestampedGaugeVec is a gaugeVec whose samples are exported with the
+// timestamps they were set with, rather than the time of the scrape.
+type timestampedGaugeVec struct {
+	desc *prometheus.Desc
+
+	mu       sync.Mutex
+	children map[string]*timestampedChild
+}
+
+func newTimestampedGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *timestampedGaugeVec {
+	return &timestampedGaugeVec{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help,
+			labelNames,
+			opts.ConstLabels,
+		),
+		children: make(map[string]*timestampedChild),
+	}
+}
+
+func (v *timestampedGaugeVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+func (v *timestampedGaugeVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	children := make([]*timestampedChild, 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.Unlock()
+
+	for _, c := range children {
+		c.mu.Lock()
+		value, timestamp := c.value, c.timestamp
+		c.mu.Unlock()
+
+		m, err := prometheus.NewConstMetric(v.desc, prometheus.GaugeValue, value, c.labelValues...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(v.desc, err)
+		} else if !timestamp.IsZero() {
+			m = prometheus.NewMetricWithTimestamp(timestamp, m)
+		}
+		ch <- m
+	}
+}
+
+func (v *timestampedGaugeVec) with(labelValues ...string) gauge {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.children[key]
+	if !ok {
+		c = &timestampedChild{labelValues: labelValues}
+		v.children[key] = c
+	}
+
+	return c
+}
+
+func (v *timestampedGaugeVec) DeleteLabelValues(labelValues ...string) bool {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, ok := v.children[key]
+	delete(v.children, key)
+
+	return ok
+}
+
+// timestampedChild is a single series of a timestampedGaugeVec
+type timestampedChild struct {
+	labelValues []string
+
+	mu        sync.Mutex
+	value     float64
+	timestamp time.Time
+}
+
+// Set sets the value without a timestamp, so it is exported with the time of
+// the scrape.
+func (c *timestampedChild) Set(v float64) {
+	c.SetWithTimestamp(v, time.Time{})
+}
+
+func (c *timestampedChild) SetWithTimestamp(v float64, t time.Time) {
+	c.mu.Lock()
+	c.value, c.timestamp = v, t
+	c.mu.Unlock()
+}
@@ -0,0 +1,65 @@
+package exportcloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(c prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var ret []*dto.Metric
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			panic(err)
+		}
+		ret = append(ret, pb)
+	}
+
+	return ret
+}
+
+func TestTimestampedGaugeVec(t *testing.T) {
+	v := newTimestampedGaugeVec(prometheus.GaugeOpts{Name: "test_timestamped_gauge_vec"}, []string{"queue_name"})
+	ts := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	v.with("a").(timestampedGauge).SetWithTimestamp(1, ts)
+	v.with("b").Set(2)
+
+	got := map[string]*dto.Metric{}
+	for _, m := range collect(v) {
+		got[m.Label[0].GetValue()] = m
+	}
+	assert.Equal(t, float64(1), got["a"].Gauge.GetValue())
+	assert.Equal(t, ts.UnixNano()/int64(time.Millisecond), got["a"].GetTimestampMs())
+	assert.Equal(t, float64(2), got["b"].Gauge.GetValue())
+	assert.Nil(t, got["b"].TimestampMs, "values set without a timestamp are exported without one")
+
+	assert.True(t, v.DeleteLabelValues("a"))
+	assert.False(t, v.DeleteLabelValues("a"))
+	assert.Equal(t, 1, testutil.CollectAndCount(v))
+}
+
+func TestReadMetricsTimestamps(t *testing.T) {
+	v := newTimestampedGaugeVec(prometheus.GaugeOpts{Name: "test_read_metrics_timestamps"}, []string{"queue_name"})
+	metricstats := map[string]MetricStat{
+		"i0": {statistic: "Sum", gauge: v.with("a")},
+	}
+
+	err := ReadMetrics([]Client{{CloudWatch: stubCloudWatch{}}}, time.Now(), metricstats, 1)
+
+	assert.NoError(t, err)
+	m := collect(v)[0]
+	assert.Equal(t, float64(1), m.Gauge.GetValue())
+	assert.Equal(t, stubTimestamp.UnixNano()/int64(time.Millisecond), m.GetTimestampMs())
+}
@@ -2,10 +2,14 @@ package exportcloudwatch
 
 import (
 	"fmt"
+	"log"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/pkg/errors"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -31,13 +35,59 @@ type ExportConfig struct {
 	// Namespace and Name map directly to metrics in AWS Cloudwatch
 	Namespace, Name string
 
+	// NameMatch and NameNoMatch select the metrics of Namespace to export by
+	// name, in place of Name; the gauges of each name are registered as the
+	// name is found
+	NameMatch, NameNoMatch *regexp.Regexp
+
 	// Dimensions are the names of dimensions to pull the data of, and Statistics
-	// are the Statistics to pull
+	// are the Statistics to pull; these can be extended statistics like p99 or
+	// TM(10%:90%)
 	Dimensions, Statistics []string
 
-	// Both of these filter the metrics based on the values of the dimension
+	// OptionalDimensions are labeled like Dimensions, but metrics without
+	// them are exported too, with empty labels; ExtraDimensions exports
+	// metrics that have dimensions other than these, which are ignored.
+	// Metrics whose labels then duplicate another metric's are dropped, keeping
+	// the one with the fewest dimensions.
+	OptionalDimensions []string
+	ExtraDimensions    bool
+
+	// AggregateBy exports one series per value of these of the Dimensions,
+	// read as the Aggregation (SUM, MAX, MIN or AVG; SUM by default) of the
+	// metrics with that value; the other dimensions aren't labeled
+	AggregateBy []string
+	Aggregation string
+
+	// QuantileLabel exports the percentile Statistics as one gauge with a
+	// quantile label, rather than a gauge per percentile
+	QuantileLabel bool
+
+	// Counter exports the Sum statistic as a counter that every datapoint of
+	// the Sum is added to, once, rather than as a gauge of the latest
+	// datapoint
+	Counter bool
+
+	// Unit is the CloudWatch unit the metric is read in, like Milliseconds;
+	// values are converted to the prometheus base unit, like seconds, whose
+	// suffix is added to the exported names
+	Unit string
+
+	// Summary exports the SampleCount, Sum and percentile Statistics as one
+	// prometheus summary, rather than a gauge each, whose count and sum every
+	// datapoint is added to once; the other Statistics are still exported as
+	// gauges
+	Summary bool
+
+	// Both of these filter the metrics based on the values of the dimension;
+	// a missing OptionalDimension has an empty value
 	DimensionsMatch, DimensionsNoMatch map[string]*regexp.Regexp
 
+	// Both of these filter the metrics based on the values of the tags, by
+	// key, of the AWS resource named by the Dimensions; a missing tag has an
+	// empty value.  They need Clients with Tagging.
+	TagsMatch, TagsNoMatch map[string]*regexp.Regexp
+
 	// StatDefault determines the default value for a stat if no value is read
 	StatDefault StatDefaultType
 
@@ -46,8 +96,188 @@ type ExportConfig struct {
 	// 1 is the new naming scheme, which should result in fewer overlaps in derived metric names
 	NameDerivationVersion uint
 
+	// PrometheusName replaces the part of the exported names derived from
+	// Namespace and Name; the statistic is still appended
+	PrometheusName string
+
+	// Prefix is prepended to the exported names, separated by an underscore
+	Prefix string
+
+	// LabelNames renames the labels of Dimensions, which are otherwise derived
+	// from the dimension names
+	LabelNames map[string]string
+
+	// ConstLabels are added to every exported series, and LabelTemplates
+	// derive labels from the values of Dimensions
+	ConstLabels    map[string]string
+	LabelTemplates []LabelTemplate
+
+	// TagLabels adds labels with the values of the tags of the AWS resource
+	// named by the Dimensions, by tag key, and ResourceInfo exports all the
+	// tags of the resources on aws_resource_info; both need Clients with
+	// Tagging
+	TagLabels    map[string]string
+	ResourceInfo bool
+
+	// Regions limits the export to the named regions; if empty the metrics are
+	// exported from every region there is a Client for
+	Regions []string
+
+	// Accounts limits the export to the named account IDs; if empty the metrics
+	// are exported from every account there is a Client for
+	Accounts []string
+
+	// GracePeriod is how long a metric that is no longer listed by CloudWatch
+	// keeps being exported; after that its series is removed
+	GracePeriod time.Duration
+
+	// Period is the length of each datapoint read (1 minute by default); Delay
+	// is how long before now the datapoints read end, to allow for CloudWatch
+	// publishing late, and Range is the length of time read.  Delay defaults to
+	// one Period if nil, and Range to five Periods.  The latest datapoint in
+	// the Range is exported.
+	Period, Range time.Duration
+	Delay         *time.Duration
+
+	// IncludeIncomplete exports the datapoint of the Period that is still in
+	// progress at now minus Delay; by default it is skipped
+	IncludeIncomplete bool
+
+	// Timestamps exports samples with the time of the CloudWatch datapoint,
+	// rather than the time of the scrape
+	Timestamps bool
+
+	// Search discovers the metrics to export with a GetMetricData SEARCH
+	// expression instead of ListMetrics, which skips metrics with no data in
+	// the Range
+	Search bool
+
 	// each collector maps to the statistic in the same location
-	collectors []*prometheus.GaugeVec
+	collectors []gaugeVec
+
+	// labels are the label names of the collectors, and named holds the
+	// collectors of each name found with NameMatch and NameNoMatch
+	labels []string
+	named  *namedCollectors
+
+	// tagKeys are the keys of TagLabels in the order of their labels, and
+	// resource is the kind of resource the tags are of
+	tagKeys  []string
+	resource resourceKind
+
+	// info exports the tags of the resources if ResourceInfo is set
+	info *resourceInfoCollector
+
+	// dropped records the metrics dropped as duplicates with ExtraDimensions
+	dropped *droppedMetrics
+}
+
+// LabelTemplate derives a label from the value of a dimension
+type LabelTemplate struct {
+	// Name is the name of the label
+	Name string
+
+	// The value of the label is Template, like "$1", expanded with the
+	// submatches of Regexp in the value of Dimension; if Regexp doesn't match
+	// the value is empty
+	Dimension string
+	Regexp    *regexp.Regexp
+	Template  string
+}
+
+// value returns the value of the label of t for the dimension value v
+func (t LabelTemplate) value(v string) string {
+	m := t.Regexp.FindStringSubmatchIndex(v)
+	if m == nil {
+		return ""
+	}
+
+	return string(t.Regexp.ExpandString(nil, t.Template, v, m))
+}
+
+// namedCollectors are the collectors of the metric names an ExportConfig
+// matches, registered as they are found
+type namedCollectors struct {
+	mu         sync.Mutex
+	collectors map[string][]gaugeVec
+	errs       map[string]error
+}
+
+// droppedMetrics records the metrics an ExportConfig dropped, so that each is
+// logged once
+type droppedMetrics struct {
+	mu      sync.Mutex
+	metrics map[string]bool
+}
+
+// add records the metric called key, returning false if it was already
+// recorded; a nil droppedMetrics records nothing
+func (d *droppedMetrics) add(key string) bool {
+	if d == nil {
+		return true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.metrics[key] {
+		return false
+	}
+	d.metrics[key] = true
+
+	return true
+}
+
+// matchesNames returns true if e selects metrics with NameMatch or
+// NameNoMatch rather than Name
+func (e *ExportConfig) matchesNames() bool {
+	return e.NameMatch != nil || e.NameNoMatch != nil
+}
+
+// accountLabel and regionLabel are the labels every exported metric gets,
+// holding the account and region the metric was read from
+const (
+	accountLabel = "account_id"
+	regionLabel  = "region"
+)
+
+// arnLabel is the label of aws_resource_info holding the ARN of the resource
+const arnLabel = "arn"
+
+// quantileLabel is the label of the percentiles of an ExportConfig with
+// QuantileLabel set
+const quantileLabel = "quantile"
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// includesClient returns true if c is in one of regions and accounts, where
+// empty lists include everything
+func includesClient(regions, accounts []string, c Client) bool {
+	if len(regions) != 0 && !contains(regions, c.Region) {
+		return false
+	}
+
+	if len(accounts) != 0 && !contains(accounts, c.AccountID) {
+		return false
+	}
+
+	return true
+}
+
+func (e *ExportConfig) includesClient(c Client) bool {
+	return includesClient(e.Regions, e.Accounts, c)
+}
+
+func (e *ExportConfig) window() window {
+	return newWindow(e.Period, e.Delay, e.Range, e.IncludeIncomplete)
 }
 
 func (e *ExportConfig) isDynamodDBIndexMetric() bool {
@@ -104,21 +334,158 @@ func (e *ExportConfig) cloudWatchToPrometheusName(base string) string {
 }
 
 func (e *ExportConfig) String(i int) string {
+	return e.prometheusName(e.Name, i)
+}
+
+// prometheusName returns the name of the gauge of the metric called name and
+// the statistic at i
+func (e *ExportConfig) prometheusName(name string, i int) string {
+	// extended statistics don't survive the name conversion, so they are
+	// mapped to a suffix of their own
+	stat, suffix := e.Statistics[i], ""
+	if e.hasQuantile(i) || e.inSummary(i) {
+		stat = ""
+	} else if !standardStatistics[stat] {
+		stat, suffix = "", "_"+statisticSuffix(stat)
+	}
+
+	if e.Unit != "" && (e.inSummary(i) || statisticHasUnit(e.Statistics[i])) {
+		suffix += baseUnits[e.Unit].suffix
+	}
+	if e.isCounter(i) {
+		suffix += "_total"
+	}
+
 	var base string
-	if e.isDynamodDBIndexMetric() {
-		base = e.Name + "Index" + e.Statistics[i]
-	} else if e.isRDSDBClusterMetric() {
-		base = e.Name + "Cluster" + e.Statistics[i]
-	} else if e.isRDSDBInstanceMetric() {
-		base = e.Name + "Instance" + e.Statistics[i]
+	if e.PrometheusName != "" {
+		base = e.PrometheusName
+		if stat != "" {
+			base += "_" + e.cloudWatchToPrometheusName(stat)
+		}
 	} else {
-		base = e.Name + e.Statistics[i]
+		if e.isDynamodDBIndexMetric() {
+			base = name + "Index" + stat
+		} else if e.isRDSDBClusterMetric() {
+			base = name + "Cluster" + stat
+		} else if e.isRDSDBInstanceMetric() {
+			base = name + "Instance" + stat
+		} else {
+			base = name + stat
+		}
+
+		base = strings.ToLower(e.Namespace) + "_" + e.cloudWatchToPrometheusName(base)
+		base = strings.ReplaceAll(base, "/", "_")
+	}
+
+	if e.Prefix != "" {
+		base = e.Prefix + "_" + base
+	}
+
+	return base + suffix
+}
+
+// labelName returns the name of the label of dimension d
+func (e *ExportConfig) labelName(d string) string {
+	if l, ok := e.LabelNames[d]; ok {
+		return l
 	}
 
-	base = strings.ToLower(e.Namespace) + "_" + e.cloudWatchToPrometheusName(base)
-	base = strings.ReplaceAll(base, "/", "_")
+	return e.cloudWatchToPrometheusName(d)
+}
+
+// scale returns what the values of the statistic at i are multiplied by to
+// convert them to the base unit
+func (e *ExportConfig) scale(i int) float64 {
+	if e.Unit == "" || !statisticHasUnit(e.Statistics[i]) {
+		return 1
+	}
+
+	return baseUnits[e.Unit].scale
+}
 
-	return base
+// isCounter returns true if the statistic at i is exported as a counter
+func (e *ExportConfig) isCounter(i int) bool {
+	return e.Counter && e.Statistics[i] == "Sum"
+}
+
+// inSummary returns true if the statistic at i is part of a summary
+func (e *ExportConfig) inSummary(i int) bool {
+	s := e.Statistics[i]
+	return e.Summary && (s == "SampleCount" || s == "Sum" || isPercentile(s))
+}
+
+// reservedLabel returns true if name is a label the exporter adds itself
+func (e *ExportConfig) reservedLabel(name string) bool {
+	return name == accountLabel || name == regionLabel || ((e.QuantileLabel || e.Summary) && name == quantileLabel) ||
+		(e.ResourceInfo && name == arnLabel)
+}
+
+// checkExtraLabel returns an error if name isn't a valid name for a constant
+// or templated label, given the labels already labeled
+func (e *ExportConfig) checkExtraLabel(name string, labeled map[string]string) error {
+	if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+		return errors.New("Invalid label name " + name)
+	}
+	if e.reservedLabel(name) {
+		return errors.New("Label " + name + " is reserved")
+	}
+	if _, ok := labeled[name]; ok {
+		return errors.New("Label " + name + " is used more than once")
+	}
+
+	return nil
+}
+
+// templatedValues returns the values of the LabelTemplates for the dimensions
+// of metric
+func (e *ExportConfig) templatedValues(metric *cloudwatch.Metric) []string {
+	values := make([]string, len(e.LabelTemplates))
+	for i, t := range e.LabelTemplates {
+		values[i] = t.value(dimensionValue(metric, t.Dimension))
+	}
+
+	return values
+}
+
+// tagged returns true if e needs the tags of its resources
+func (e *ExportConfig) tagged() bool {
+	return len(e.TagLabels) != 0 || e.ResourceInfo || e.filtersTags()
+}
+
+// labeledDimensions returns the sorted dimensions that are labeled, in that
+// order
+func (e *ExportConfig) labeledDimensions() []string {
+	if e.aggregated() {
+		return e.AggregateBy
+	}
+
+	return e.dimensions()
+}
+
+// dimensions returns the sorted Dimensions and OptionalDimensions
+func (e *ExportConfig) dimensions() []string {
+	if len(e.OptionalDimensions) == 0 {
+		return e.Dimensions
+	}
+
+	dims := make([]string, 0, len(e.Dimensions)+len(e.OptionalDimensions))
+	dims = append(dims, e.Dimensions...)
+	dims = append(dims, e.OptionalDimensions...)
+	sort.Strings(dims)
+
+	return dims
+}
+
+// filtersTags returns true if e filters metrics by the tags of their
+// resources
+func (e *ExportConfig) filtersTags() bool {
+	return len(e.TagsMatch) != 0 || len(e.TagsNoMatch) != 0
+}
+
+// hasQuantile returns true if the statistic at i is exported with a quantile
+// label
+func (e *ExportConfig) hasQuantile(i int) bool {
+	return e.QuantileLabel && isPercentile(e.Statistics[i])
 }
 
 // Validate returns an error if the configuration is incorrect and registers
@@ -128,24 +495,109 @@ func (e *ExportConfig) Validate() error {
 		return errors.New("At least one statistic is required")
 	}
 
+	for _, s := range e.Statistics {
+		if !validStatistic(s) {
+			return errors.New("Invalid statistic " + s)
+		}
+	}
+
+	if _, ok := baseUnits[e.Unit]; e.Unit != "" && !ok {
+		return errors.New("Invalid Unit " + e.Unit)
+	}
+
+	if e.Counter {
+		if !contains(e.Statistics, "Sum") {
+			return errors.New("Counter requires the Sum statistic")
+		}
+		if e.Summary {
+			return errors.New("Counter can't be used with Summary")
+		}
+		if e.IncludeIncomplete {
+			return errors.New("Counter can't be used with IncludeIncomplete")
+		}
+	}
+
+	if e.Summary {
+		if e.QuantileLabel {
+			return errors.New("QuantileLabel can't be used with Summary")
+		}
+		if !contains(e.Statistics, "SampleCount") || !contains(e.Statistics, "Sum") {
+			return errors.New("Summary requires the SampleCount and Sum statistics")
+		}
+		if e.IncludeIncomplete {
+			return errors.New("Summary can't be used with IncludeIncomplete")
+		}
+	}
+
 	if e.NameDerivationVersion > 1 {
 		return errors.New("Invalid NameDerivationVersion (must be 0 or 1)")
 	}
 
+	if e.matchesNames() && e.Name != "" {
+		return errors.New("Name can't be used with NameMatch or NameNoMatch")
+	}
+
+	if e.matchesNames() && e.PrometheusName != "" {
+		return errors.New("PrometheusName can't be used with NameMatch or NameNoMatch")
+	}
+
+	// with NameMatch the names are only built as they are found, so these are
+	// checked up front
+	if e.Prefix != "" && !metricNameRE.MatchString(e.Prefix) {
+		return errors.New("Invalid Prefix " + e.Prefix)
+	}
+	if e.PrometheusName != "" && !metricNameRE.MatchString(e.PrometheusName) {
+		return errors.New("Invalid PrometheusName " + e.PrometheusName)
+	}
+
+	if e.GracePeriod < 0 {
+		return errors.New("GracePeriod must not be negative")
+	}
+
+	if err := e.window().validate(); err != nil {
+		return err
+	}
+
 	// these to cheaply compare to other list at runtime
 	sort.Strings(e.Dimensions)
+	sort.Strings(e.OptionalDimensions)
+
+	for _, d := range e.OptionalDimensions {
+		if contains(e.Dimensions, d) {
+			return errors.New("Dimension " + d + " is in both Dimensions and OptionalDimensions")
+		}
+	}
+
+	// SEARCH only finds metrics with exactly the dimensions of its schema
+	if e.Search && (len(e.OptionalDimensions) != 0 || e.ExtraDimensions) {
+		return errors.New("Search can't be used with OptionalDimensions or ExtraDimensions")
+	}
+
+	dimensions := e.dimensions()
+
+	sort.Strings(e.AggregateBy)
+	for _, d := range e.AggregateBy {
+		if !contains(dimensions, d) {
+			return errors.New("AggregateBy name not in Dimensions")
+		}
+	}
+	if e.Aggregation != "" && !e.aggregated() {
+		return errors.New("Aggregation requires AggregateBy")
+	}
+	if e.Aggregation != "" && !aggregations[e.Aggregation] {
+		return errors.New("Invalid Aggregation " + e.Aggregation)
+	}
+	if e.aggregated() && e.Summary {
+		return errors.New("AggregateBy can't be used with Summary")
+	}
+	if e.aggregated() && (len(e.TagLabels) != 0 || e.ResourceInfo) {
+		return errors.New("AggregateBy can't be used with TagLabels or ResourceInfo")
+	}
 
 	for k := range e.DimensionsMatch {
 		// verify that we are matching against dimensions we are going to be
 		// using
-		var found bool
-		for _, d := range e.Dimensions {
-			if k == d {
-				found = true
-				break
-			}
-		}
-		if !found {
+		if !contains(dimensions, k) {
 			return errors.New("DimensionsMatch name not in Dimensions")
 		}
 	}
@@ -153,33 +605,200 @@ func (e *ExportConfig) Validate() error {
 	for k := range e.DimensionsNoMatch {
 		// verify that we are matching against dimensions we are going to be
 		// using
-		var found bool
-		for _, d := range e.Dimensions {
-			if k == d {
-				found = true
-				break
-			}
-		}
-		if !found {
+		if !contains(dimensions, k) {
 			return errors.New("DimensionsNoMatch name not in Dimensions")
 		}
 	}
 
-	e.collectors = make([]*prometheus.GaugeVec, len(e.Statistics))
-	aliasedDimensions := make([]string, len(e.Dimensions))
-	for j, d := range e.Dimensions {
-		aliasedDimensions[j] = e.cloudWatchToPrometheusName(d)
+	for k := range e.LabelNames {
+		if !contains(dimensions, k) {
+			return errors.New("LabelNames name not in Dimensions")
+		}
+	}
+
+	// only the dimensions of the aggregates are labeled
+	dimensions = e.labeledDimensions()
+
+	aliasedDimensions := make([]string, 0, len(dimensions)+len(e.LabelTemplates)+len(e.TagLabels)+2)
+	aliasedDimensions = append(aliasedDimensions, accountLabel, regionLabel)
+	labeled := make(map[string]string, len(dimensions))
+	for _, d := range dimensions {
+		alias := e.labelName(d)
+		if !labelNameRE.MatchString(alias) || strings.HasPrefix(alias, "__") {
+			return errors.New("Invalid label name " + alias + " for dimension " + d)
+		}
+		if e.reservedLabel(alias) {
+			return errors.New("Dimension " + d + " collides with the " + alias + " label")
+		}
+		if other, ok := labeled[alias]; ok {
+			return errors.New("Dimensions " + other + " and " + d + " are both labeled " + alias)
+		}
+		labeled[alias] = d
+		aliasedDimensions = append(aliasedDimensions, alias)
+	}
+
+	for _, t := range e.LabelTemplates {
+		if !contains(dimensions, t.Dimension) {
+			return errors.New("LabelTemplates dimension not in Dimensions")
+		}
+		if t.Regexp == nil || t.Template == "" {
+			return errors.New("LabelTemplate " + t.Name + " needs a Regexp and Template")
+		}
+		if err := e.checkExtraLabel(t.Name, labeled); err != nil {
+			return err
+		}
+		labeled[t.Name] = "LabelTemplates"
+		aliasedDimensions = append(aliasedDimensions, t.Name)
+	}
+
+	if e.tagged() {
+		var ok bool
+		if e.resource, ok = e.resourceKindOf(); !ok {
+			return errors.New("No taggable resource in the Dimensions of Namespace " + e.Namespace)
+		}
+	}
+
+	// unchecked collectors can't be unregistered, so a config validated
+	// again keeps its collector
+	if e.ResourceInfo && e.info == nil {
+		e.info = &resourceInfoCollector{}
+		if err := prometheus.Register(e.info); err != nil {
+			return errors.Wrap(err, "Namespace="+e.Namespace+" ResourceInfo")
+		}
+	}
+
+	if e.dropped == nil {
+		e.dropped = &droppedMetrics{metrics: make(map[string]bool)}
+	}
+
+	e.tagKeys = nil
+	for k := range e.TagLabels {
+		e.tagKeys = append(e.tagKeys, k)
+	}
+	sort.Strings(e.tagKeys)
+	for _, k := range e.tagKeys {
+		if err := e.checkExtraLabel(e.TagLabels[k], labeled); err != nil {
+			return err
+		}
+		labeled[e.TagLabels[k]] = "TagLabels"
+		aliasedDimensions = append(aliasedDimensions, e.TagLabels[k])
 	}
 
+	for k := range e.ConstLabels {
+		if err := e.checkExtraLabel(k, labeled); err != nil {
+			return err
+		}
+	}
+
+	e.labels = aliasedDimensions
+
+	if e.matchesNames() {
+		e.named = &namedCollectors{
+			collectors: make(map[string][]gaugeVec),
+			errs:       make(map[string]error),
+		}
+		return nil
+	}
+
+	var err error
+	e.collectors, err = e.newCollectors(e.Name)
+	return err
+}
+
+// newCollectors creates and registers the collectors of the metric called
+// name
+func (e *ExportConfig) newCollectors(name string) ([]gaugeVec, error) {
 	for j := range e.Statistics {
-		e.collectors[j] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: e.String(j),
-			Help: "",
-		}, aliasedDimensions)
-		if err := prometheus.Register(e.collectors[j]); err != nil {
-			return errors.Wrap(err, "Namespace="+e.Namespace+" Name="+e.Name)
+		if n := e.prometheusName(name, j); !metricNameRE.MatchString(n) {
+			return nil, errors.New("Invalid metric name " + n)
 		}
 	}
 
-	return nil
+	collectors := make([]gaugeVec, len(e.Statistics))
+
+	// the percentiles share a collector if they have a quantile label, and
+	// the parts of a summary share theirs
+	var quantiles gaugeVec
+	var summary *summaryVec
+	for j, s := range e.Statistics {
+		if e.hasQuantile(j) && quantiles != nil {
+			collectors[j] = quantiles
+			continue
+		}
+		if e.inSummary(j) && summary != nil {
+			collectors[j] = summary.part(s)
+			continue
+		}
+
+		opts := prometheus.GaugeOpts{
+			Name:        e.prometheusName(name, j),
+			Help:        "",
+			ConstLabels: e.ConstLabels,
+		}
+
+		labels := e.labels
+		if e.hasQuantile(j) {
+			labels = append(labels[:len(labels):len(labels)], quantileLabel)
+		}
+		if e.inSummary(j) {
+			summary = newSummaryVec(opts, labels, e.Timestamps)
+			collectors[j] = summary.part(s)
+		} else if e.isCounter(j) {
+			collectors[j] = newCounterVec(opts, labels, e.Timestamps)
+		} else if e.Timestamps {
+			collectors[j] = newTimestampedGaugeVec(opts, labels)
+		} else {
+			collectors[j] = promGaugeVec{prometheus.NewGaugeVec(opts, labels)}
+		}
+		if e.hasQuantile(j) {
+			quantiles = collectors[j]
+		}
+		if err := prometheus.Register(collectors[j]); err != nil {
+			for _, c := range collectors[:j] {
+				prometheus.Unregister(c)
+			}
+			return nil, errors.Wrap(err, "Namespace="+e.Namespace+" Name="+name)
+		}
+	}
+
+	return collectors, nil
+}
+
+var skippedMetricNames = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "monitoring_cloudwatch_skipped_metric_names_total",
+	Help: "Count of metric names matched by NameMatch whose gauges couldn't be registered, by namespace",
+}, []string{"namespace"})
+
+func init() {
+	prometheus.MustRegister(skippedMetricNames)
+}
+
+// collectorsFor returns the collectors of the metric called name, registering
+// them the first time a name matched by NameMatch and NameNoMatch is found.
+// A name whose collectors can't be registered is logged and counted once.
+func (e *ExportConfig) collectorsFor(name string) ([]gaugeVec, error) {
+	if e.named == nil {
+		return e.collectors, nil
+	}
+
+	e.named.mu.Lock()
+	defer e.named.mu.Unlock()
+
+	if c, ok := e.named.collectors[name]; ok {
+		return c, nil
+	}
+	if err, ok := e.named.errs[name]; ok {
+		return nil, err
+	}
+
+	c, err := e.newCollectors(name)
+	if err != nil {
+		log.Print(err)
+		skippedMetricNames.WithLabelValues(e.Namespace).Inc()
+		e.named.errs[name] = err
+		return nil, err
+	}
+	e.named.collectors[name] = c
+
+	return c, nil
 }
@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,8 +20,12 @@ type validateTest struct {
 func xxxAxeCollectors(c []ExportConfig) {
 	for i := range c {
 		c[i].collectors = nil
+		c[i].labels = nil
+		c[i].named = nil
+		c[i].dropped = nil
 		c[i].DimensionsMatch = nil
 		c[i].DimensionsNoMatch = nil
+		c[i].LabelTemplates = nil
 	}
 }
 
@@ -89,6 +94,227 @@ func TestValidate(t *testing.T) {
 
 			err: errors.New("DimensionsNoMatch name not in Dimensions"),
 		},
+		{
+			name: "Name and NameMatch",
+
+			in: []ExportConfig{
+				{
+					Namespace:  "AWS/ApplicationELB",
+					Name:       "RequestCount",
+					NameMatch:  regexp.MustCompile("Count$"),
+					Statistics: []string{"Sum"},
+				},
+			},
+
+			err: errors.New("Name can't be used with NameMatch or NameNoMatch"),
+		},
+		{
+			name: "Unknown LabelNames Dimension",
+
+			in: []ExportConfig{
+				{
+					Namespace:  "AWS/SQS",
+					Name:       "ApproximateAgeOfOldestMessage",
+					Statistics: []string{"Maximum"},
+					Dimensions: []string{"QueueName"},
+					LabelNames: map[string]string{"TableName": "table"},
+				},
+			},
+
+			err: errors.New("LabelNames name not in Dimensions"),
+		},
+		{
+			name: "Invalid Label Name",
+
+			in: []ExportConfig{
+				{
+					Namespace:  "AWS/SQS",
+					Name:       "ApproximateAgeOfOldestMessage",
+					Statistics: []string{"Maximum"},
+					Dimensions: []string{"QueueName"},
+					LabelNames: map[string]string{"QueueName": "queue-name"},
+				},
+			},
+
+			err: errors.New("Invalid label name queue-name for dimension QueueName"),
+		},
+		{
+			name: "Duplicate Label Name",
+
+			in: []ExportConfig{
+				{
+					Namespace:  "AWS/ApplicationELB",
+					Name:       "RequestCount",
+					Statistics: []string{"Sum"},
+					Dimensions: []string{"LoadBalancer", "TargetGroup"},
+					LabelNames: map[string]string{"TargetGroup": "load_balancer"},
+				},
+			},
+
+			err: errors.New("Dimensions LoadBalancer and TargetGroup are both labeled load_balancer"),
+		},
+		{
+			name: "Invalid PrometheusName",
+
+			in: []ExportConfig{
+				{
+					Namespace:      "AWS/SQS",
+					Name:           "ApproximateAgeOfOldestMessage",
+					PrometheusName: "sqs-age",
+					Statistics:     []string{"Maximum"},
+				},
+			},
+
+			err: errors.New("Invalid PrometheusName sqs-age"),
+		},
+		{
+			name: "Reserved Const Label",
+
+			in: []ExportConfig{
+				{
+					Namespace:   "AWS/SQS",
+					Name:        "ApproximateAgeOfOldestMessage",
+					Statistics:  []string{"Maximum"},
+					ConstLabels: map[string]string{"region": "moon"},
+				},
+			},
+
+			err: errors.New("Label region is reserved"),
+		},
+		{
+			name: "Const Label Collides With Template",
+
+			in: []ExportConfig{
+				{
+					Namespace:   "AWS/SQS",
+					Name:        "ApproximateAgeOfOldestMessage",
+					Statistics:  []string{"Maximum"},
+					Dimensions:  []string{"QueueName"},
+					ConstLabels: map[string]string{"team": "search"},
+					LabelTemplates: []LabelTemplate{{
+						Name:      "team",
+						Dimension: "QueueName",
+						Regexp:    regexp.MustCompile("^([a-z]+)-"),
+						Template:  "$1",
+					}},
+				},
+			},
+
+			err: errors.New("Label team is used more than once"),
+		},
+		{
+			name: "Unknown LabelTemplates Dimension",
+
+			in: []ExportConfig{
+				{
+					Namespace:  "AWS/SQS",
+					Name:       "ApproximateAgeOfOldestMessage",
+					Statistics: []string{"Maximum"},
+					Dimensions: []string{"QueueName"},
+					LabelTemplates: []LabelTemplate{{
+						Name:      "team",
+						Dimension: "TableName",
+						Regexp:    regexp.MustCompile("^([a-z]+)-"),
+						Template:  "$1",
+					}},
+				},
+			},
+
+			err: errors.New("LabelTemplates dimension not in Dimensions"),
+		},
+		{
+			name: "Region Dimension",
+
+			in: []ExportConfig{
+				{
+					Namespace:  "AWS/Billing",
+					Name:       "EstimatedCharges",
+					Statistics: []string{"Maximum"},
+					Dimensions: []string{"Region"},
+				},
+			},
+
+			err: errors.New("Dimension Region collides with the region label"),
+		},
+		{
+			name: "ARN Label With ResourceInfo",
+
+			in: []ExportConfig{
+				{
+					Namespace:    "AWS/SQS",
+					Name:         "ApproximateAgeOfOldestMessage",
+					Statistics:   []string{"Maximum"},
+					Dimensions:   []string{"QueueName"},
+					LabelNames:   map[string]string{"QueueName": "arn"},
+					ResourceInfo: true,
+				},
+			},
+
+			err: errors.New("Dimension QueueName collides with the arn label"),
+		},
+		{
+			name: "ARN Tag Label With ResourceInfo",
+
+			in: []ExportConfig{
+				{
+					Namespace:    "AWS/SQS",
+					Name:         "ApproximateAgeOfOldestMessage",
+					Statistics:   []string{"Maximum"},
+					Dimensions:   []string{"QueueName"},
+					TagLabels:    map[string]string{"owner": "arn"},
+					ResourceInfo: true,
+				},
+			},
+
+			err: errors.New("Label arn is reserved"),
+		},
+		{
+			name: "Required And Optional Dimension",
+
+			in: []ExportConfig{
+				{
+					Namespace:          "AWS/SQS",
+					Name:               "ApproximateAgeOfOldestMessage",
+					Statistics:         []string{"Maximum"},
+					Dimensions:         []string{"QueueName"},
+					OptionalDimensions: []string{"QueueName"},
+				},
+			},
+
+			err: errors.New("Dimension QueueName is in both Dimensions and OptionalDimensions"),
+		},
+		{
+			name: "Optional Dimension Labeled Like Another",
+
+			in: []ExportConfig{
+				{
+					Namespace:          "AWS/ApplicationELB",
+					Name:               "RequestCount",
+					Statistics:         []string{"Sum"},
+					Dimensions:         []string{"LoadBalancer"},
+					OptionalDimensions: []string{"AvailabilityZone"},
+					LabelNames:         map[string]string{"AvailabilityZone": "load_balancer"},
+				},
+			},
+
+			err: errors.New("Dimensions AvailabilityZone and LoadBalancer are both labeled load_balancer"),
+		},
+		{
+			name: "Search With ExtraDimensions",
+
+			in: []ExportConfig{
+				{
+					Namespace:       "AWS/SQS",
+					Name:            "ApproximateAgeOfOldestMessage",
+					Statistics:      []string{"Maximum"},
+					Dimensions:      []string{"QueueName"},
+					ExtraDimensions: true,
+					Search:          true,
+				},
+			},
+
+			err: errors.New("Search can't be used with OptionalDimensions or ExtraDimensions"),
+		},
 	}
 
 	for _, test := range tests {
@@ -111,3 +337,75 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestNameOverrides(t *testing.T) {
+	e := ExportConfig{
+		Namespace:             "AWS/SQS",
+		Name:                  "ApproximateNumberOfMessagesVisible",
+		PrometheusName:        "sqs_backlog",
+		Prefix:                "test_name_overrides",
+		Dimensions:            []string{"QueueName"},
+		LabelNames:            map[string]string{"QueueName": "queue"},
+		Statistics:            []string{"Maximum", "p99"},
+		NameDerivationVersion: 1,
+	}
+	assert.NoError(t, e.Validate())
+
+	assert.Equal(t, "test_name_overrides_sqs_backlog_maximum", e.String(0))
+	assert.Equal(t, "test_name_overrides_sqs_backlog_p99", e.String(1))
+	assert.Equal(t, []string{accountLabel, regionLabel, "queue"}, e.labels)
+
+	e.PrometheusName = ""
+	assert.Equal(t, "test_name_overrides_aws_sqs_approximate_number_of_messages_visible_maximum", e.String(0))
+
+	matched := ExportConfig{
+		Namespace:  "AWS/SQS",
+		NameMatch:  regexp.MustCompile("^Approximate"),
+		Prefix:     "test-name-overrides",
+		Dimensions: []string{"QueueName"},
+		Statistics: []string{"Maximum"},
+	}
+	assert.EqualError(t, matched.Validate(), "Invalid Prefix test-name-overrides")
+
+	e.PrometheusName = "sqs backlog"
+	assert.EqualError(t, e.Validate(), "Invalid PrometheusName sqs backlog")
+}
+
+func TestExtraLabels(t *testing.T) {
+	e := ExportConfig{
+		Namespace:   "AWS/SQS",
+		Name:        "TestExtraLabels",
+		Dimensions:  []string{"QueueName"},
+		Statistics:  []string{"Maximum"},
+		ConstLabels: map[string]string{"environment": "production"},
+		LabelTemplates: []LabelTemplate{{
+			Name:      "team",
+			Dimension: "QueueName",
+			Regexp:    regexp.MustCompile(`^([a-z]+)-(\w+)$`),
+			Template:  "team-$1",
+		}},
+	}
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, []string{accountLabel, regionLabel, "queue_name", "team"}, e.labels)
+
+	scw := stubCloudWatch{metrics: []*cloudwatch.Metric{
+		newMetric("AWS/SQS", "TestExtraLabels", "QueueName", "search-indexer"),
+		newMetric("AWS/SQS", "TestExtraLabels", "QueueName", "Orphan"),
+	}}
+	ms, err := metricsToRead([]ExportConfig{e}, []Client{{Region: "us-east-1", CloudWatch: scw}})
+	assert.NoError(t, err)
+
+	var got [][]string
+	for _, m := range ms {
+		got = append(got, m.labelValues)
+	}
+	assert.Equal(t, [][]string{
+		{"", "us-east-1", "search-indexer", "team-search"},
+		{"", "us-east-1", "Orphan", ""},
+	}, got)
+
+	for _, m := range collect(e.collectors[0]) {
+		assert.Equal(t, "environment", m.Label[1].GetName())
+		assert.Equal(t, "production", m.Label[1].GetValue())
+	}
+}
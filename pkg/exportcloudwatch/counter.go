@@ -0,0 +1,162 @@
+package exportcloudwatch
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// accumulator is a gauge that adds up the datapoints it is given instead of
+// being set to the latest one
+type accumulator interface {
+	gauge
+
+	// add adds the value of the datapoint at t, unless the datapoint at t was
+	// already added
+	add(value float64, t time.Time)
+
+	// forget drops the record of the datapoints added before start, which
+	// are no longer read
+	forget(start time.Time)
+}
+
+// addedTimes records the timestamps of the datapoints an accumulator added,
+// so that datapoints published late are still added, once
+type addedTimes map[int64]struct{}
+
+// add records t, returning false if it was already recorded
+func (a addedTimes) add(t time.Time) bool {
+	if _, ok := a[t.UnixNano()]; ok {
+		return false
+	}
+	a[t.UnixNano()] = struct{}{}
+
+	return true
+}
+
+// forget drops the times before start
+func (a addedTimes) forget(start time.Time) {
+	for k := range a {
+		if k < start.UnixNano() {
+			delete(a, k)
+		}
+	}
+}
+
+// counterVec is a collector of counters partitioned by label values, which
+// accumulate the datapoints of a statistic like Sum.
+type counterVec struct {
+	desc *prometheus.Desc
+
+	// timestamps exports each counter with the time of the latest datapoint
+	// added to it
+	timestamps bool
+
+	mu       sync.Mutex
+	children map[string]*counterChild
+}
+
+func newCounterVec(opts prometheus.GaugeOpts, labelNames []string, timestamps bool) *counterVec {
+	return &counterVec{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help,
+			labelNames,
+			opts.ConstLabels,
+		),
+		timestamps: timestamps,
+		children:   make(map[string]*counterChild),
+	}
+}
+
+func (v *counterVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+func (v *counterVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	children := make([]*counterChild, 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.Unlock()
+
+	for _, c := range children {
+		c.mu.Lock()
+		total, last := c.total, c.last
+		c.mu.Unlock()
+
+		m, err := prometheus.NewConstMetric(v.desc, prometheus.CounterValue, total, c.labelValues...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(v.desc, err)
+		} else if v.timestamps && !last.IsZero() {
+			m = prometheus.NewMetricWithTimestamp(last, m)
+		}
+		ch <- m
+	}
+}
+
+func (v *counterVec) with(labelValues ...string) gauge {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.children[key]
+	if !ok {
+		c = &counterChild{labelValues: labelValues, added: make(addedTimes)}
+		v.children[key] = c
+	}
+
+	return c
+}
+
+func (v *counterVec) DeleteLabelValues(labelValues ...string) bool {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, ok := v.children[key]
+	delete(v.children, key)
+
+	return ok
+}
+
+// counterChild is a single series of a counterVec
+type counterChild struct {
+	labelValues []string
+
+	mu    sync.Mutex
+	total float64
+	added addedTimes
+
+	// last is the time of the latest datapoint added
+	last time.Time
+}
+
+// Set does nothing, since a counter only changes by the datapoints added to
+// it; it is what a StatDefault would set.
+func (c *counterChild) Set(float64) {}
+
+func (c *counterChild) add(value float64, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.added.add(t) {
+		return
+	}
+	c.total += value
+	if t.After(c.last) {
+		c.last = t
+	}
+}
+
+func (c *counterChild) forget(start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.added.forget(start)
+}
@@ -0,0 +1,119 @@
+package exportcloudwatch
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterVec(t *testing.T) {
+	v := newCounterVec(prometheus.GaugeOpts{Name: "test_counter_vec"}, []string{"queue_name"}, true)
+	ts := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	c := v.with("a").(accumulator)
+	c.add(2, ts)
+	c.add(3, ts.Add(time.Minute))
+	c.add(5, ts.Add(time.Minute))
+	c.add(7, ts)
+	c.Set(0)
+
+	// a datapoint published late is added, without moving the timestamp back
+	c.add(11, ts.Add(-time.Minute))
+
+	got := collect(v)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, 16.0, got[0].Counter.GetValue())
+		assert.Equal(t, ts.Add(time.Minute).UnixNano()/int64(time.Millisecond), got[0].GetTimestampMs())
+	}
+
+	// once forgotten, a datapoint is added again if it is read again
+	c.forget(ts)
+	c.add(11, ts.Add(-time.Minute))
+	c.add(2, ts)
+	assert.Equal(t, 27.0, collect(v)[0].Counter.GetValue())
+
+	assert.True(t, v.DeleteLabelValues("a"))
+	assert.Empty(t, collect(v))
+}
+
+func TestReadMetricsCounter(t *testing.T) {
+	ts := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	minute := func(i int) *time.Time {
+		t := ts.Add(time.Duration(i) * time.Minute)
+		return &t
+	}
+	float := func(f float64) *float64 { return &f }
+
+	// the datapoints are split across pages newest first, the latest is
+	// incomplete, and one is NaN
+	pcw := &pagedCloudWatch{pages: []*cloudwatch.GetMetricDataOutput{{
+		MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         aws.String("i0"),
+			Values:     []*float64{float(100), float(4), float(math.NaN())},
+			Timestamps: []*time.Time{minute(4), minute(3), minute(2)},
+		}},
+	}, {
+		MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         aws.String("i0"),
+			Values:     []*float64{float(2), float(1)},
+			Timestamps: []*time.Time{minute(1), minute(0)},
+		}},
+	}}}
+
+	v := newCounterVec(prometheus.GaugeOpts{Name: "test_read_metrics_counter"}, nil, false)
+	metricstats := map[string]MetricStat{
+		"i0": {statistic: "Sum", gauge: v.with(), scale: 2},
+	}
+	now := ts.Add(5 * time.Minute)
+
+	assert.NoError(t, ReadMetrics([]Client{{CloudWatch: pcw}}, now, metricstats, 1))
+	assert.Equal(t, 14.0, collect(v)[0].Counter.GetValue())
+
+	// reading the same datapoints again adds nothing, and only the newly
+	// complete one is added
+	pcw.pages[0].MetricDataResults[0].Values[0] = float(10)
+	assert.NoError(t, ReadMetrics([]Client{{CloudWatch: pcw}}, now, metricstats, 1))
+	assert.Equal(t, 14.0, collect(v)[0].Counter.GetValue())
+
+	assert.NoError(t, ReadMetrics([]Client{{CloudWatch: pcw}}, now.Add(time.Minute), metricstats, 1))
+	assert.Equal(t, 34.0, collect(v)[0].Counter.GetValue())
+
+	// the datapoint that was NaN is published late, and is still added
+	pcw.pages[0].MetricDataResults[0].Values[2] = float(3)
+	assert.NoError(t, ReadMetrics([]Client{{CloudWatch: pcw}}, now.Add(time.Minute), metricstats, 1))
+	assert.Equal(t, 40.0, collect(v)[0].Counter.GetValue())
+}
+
+func TestCounterExportConfig(t *testing.T) {
+	e := ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "TestCounterExportConfig",
+		Statistics: []string{"Sum", "Maximum"},
+		Counter:    true,
+		Unit:       "Bytes",
+	}
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, "aws_sqs_test_counter_export_config_sum_bytes_total", e.String(0))
+	assert.Equal(t, "aws_sqs_test_counter_export_config_maximum_bytes", e.String(1))
+	assert.IsType(t, &counterVec{}, e.collectors[0])
+
+	tests := map[string]ExportConfig{
+		"Counter requires the Sum statistic": {
+			Namespace: "AWS/X", Name: "Y", Statistics: []string{"Maximum"}, Counter: true,
+		},
+		"Counter can't be used with Summary": {
+			Namespace: "AWS/X", Name: "Y", Statistics: []string{"SampleCount", "Sum"}, Counter: true, Summary: true,
+		},
+		"Counter can't be used with IncludeIncomplete": {
+			Namespace: "AWS/X", Name: "Y", Statistics: []string{"Sum"}, Counter: true, IncludeIncomplete: true,
+		},
+	}
+	for err, e := range tests {
+		assert.EqualError(t, e.Validate(), err)
+	}
+}
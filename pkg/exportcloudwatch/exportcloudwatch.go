@@ -2,9 +2,10 @@
 //
 // To use this package
 //
-//   1. create one or more ExportConfigs
+//   1. create one or more ExportConfigs or ExpressionConfigs
 //   2. call Validate() on each of them
-//   3. store the result of MetricsToRead
+//   3. store the result of MetricsToRead, in a MetricSet if it is refreshed
+//      while metrics are being read
 //   4. call ReadMetrics
 package exportcloudwatch
 
@@ -13,6 +14,8 @@ import (
 	"log"
 	"math"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -26,30 +29,220 @@ var cloudwatchGetMetricDataMessagesCounter = prometheus.NewCounterVec(prometheus
 	Help: "Count of messages we got with code dimension; see https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_MessageData.html",
 }, []string{"code"})
 
+var getMetricDataBatchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "monitoring_cloudwatch_get_metric_data_batch_errors_total",
+	Help: "Count of GetMetricData batches that failed, by namespace",
+}, []string{"namespace"})
+
+func init() {
+	prometheus.MustRegister(cloudwatchGetMetricDataMessagesCounter, getMetricDataBatchErrors)
+}
+
 // MetricStat is a specific statistic for a *cloudwatch.Metric with a related,
-// registered *prometheus.Gauge
+// registered gauge
 type MetricStat struct {
 	statistic        string
 	cloudwatchMetric *cloudwatch.Metric
-	gauge            prometheus.Gauge
+	gauge            gauge
 	statDefault      StatDefaultType
+	region           string
+	accountID        string
+	window           window
+
+	// unit is the CloudWatch unit the statistic is read in, and scale
+	// converts its values to the base unit
+	unit  string
+	scale float64
+
+	// expression is a Metric Math expression read instead of cloudwatchMetric,
+	// with inputs it refers to
+	expression string
+	inputs     []*cloudwatch.MetricDataQuery
+
+	// aggregated are the metrics read, in place of cloudwatchMetric, as one
+	// series with the Metric Math aggregation, like SUM
+	aggregation string
+	aggregated  []*cloudwatch.Metric
+
+	// collector and labelValues identify the series of gauge, so it can be
+	// deleted once the metric is no longer listed
+	collector   gaugeVec
+	labelValues []string
+	gracePeriod time.Duration
+	lastListed  time.Time
+}
+
+// namespace returns the CloudWatch namespace of m
+func (m MetricStat) namespace() string {
+	if m.expression != "" {
+		return expressionNamespace
+	}
+	if m.cloudwatchMetric == nil {
+		return ""
+	}
+
+	return aws.StringValue(m.cloudwatchMetric.Namespace)
+}
+
+// queries returns the queries to read m as id with datapoints of period
+func (m MetricStat) queries(id string, period time.Duration) []*cloudwatch.MetricDataQuery {
+	seconds := aws.Int64(int64(period / time.Second))
+	if len(m.aggregated) != 0 {
+		return m.aggregateQueries(id, seconds)
+	}
+	if m.expression == "" {
+		var unit *string
+		if m.unit != "" {
+			unit = aws.String(m.unit)
+		}
+
+		return []*cloudwatch.MetricDataQuery{{
+			Id: aws.String(id),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: m.cloudwatchMetric,
+				Period: seconds,
+				Stat:   aws.String(m.statistic),
+				Unit:   unit,
+			},
+			ReturnData: aws.Bool(true),
+		}}
+	}
+
+	mdq := make([]*cloudwatch.MetricDataQuery, 0, len(m.inputs)+1)
+	for _, in := range m.inputs {
+		q := *in
+		if q.MetricStat != nil {
+			ms := *q.MetricStat
+			ms.Period = seconds
+			q.MetricStat = &ms
+		}
+		mdq = append(mdq, &q)
+	}
+
+	return append(mdq, &cloudwatch.MetricDataQuery{
+		Id:         aws.String(id),
+		Expression: aws.String(m.expression),
+		Period:     seconds,
+		ReturnData: aws.Bool(true),
+	})
+}
+
+// readBy returns true if c is the client that reads m
+func (m MetricStat) readBy(c Client) bool {
+	return m.region == c.Region && m.accountID == c.AccountID
 }
 
 type MetricDataGetter interface {
 	GetMetricData(*cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error)
 }
 
-func getMetricData(cw MetricDataGetter, start, end time.Time, mdq []*cloudwatch.MetricDataQuery, unrolled map[string]MetricStat, seen map[string]struct{}) error {
+// CloudWatch is the subset of *cloudwatch.CloudWatch used by this package
+type CloudWatch interface {
+	MetricDataGetter
+	ListMetrics(*cloudwatch.ListMetricsInput) (*cloudwatch.ListMetricsOutput, error)
+}
+
+// Client is a CloudWatch client for a single region of a single account
+type Client struct {
+	Region, AccountID string
+	CloudWatch        CloudWatch
+
+	// Tagging looks up the tags of resources; it is only needed for
+	// ExportConfigs with TagLabels or ResourceInfo
+	Tagging Tagging
+}
+
+// seenSet records the ids of the MetricStats that were read, with the time of
+// the datapoint read; it is safe for concurrent use
+type seenSet struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+// newer records that the datapoint at t was read for id, returning false if a
+// later datapoint was already read.
+func (s *seenSet) newer(id string, t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if prior, ok := s.ids[id]; ok && !t.After(prior) {
+		return false
+	}
+	s.ids[id] = t
+
+	return true
+}
+
+// latestDatapoint returns the most recent value of r that isn't NaN, skipping
+// datapoints whose period ends after complete unless complete is zero.
+func latestDatapoint(r *cloudwatch.MetricDataResult, period time.Duration, complete time.Time) (float64, time.Time, bool) {
+	// without timestamps the values can't be told apart
+	if len(r.Timestamps) != len(r.Values) {
+		if len(r.Values) == 0 {
+			return 0, time.Time{}, false
+		}
+		return *r.Values[0], time.Time{}, true
+	}
+
+	var value float64
+	var latest time.Time
+	var found bool
+	for i, v := range r.Values {
+		t := *r.Timestamps[i]
+		if math.IsNaN(*v) || (found && !t.After(latest)) {
+			continue
+		}
+		if !complete.IsZero() && t.Add(period).After(complete) {
+			continue
+		}
+		value, latest, found = *v, t, true
+	}
+
+	return value, latest, found
+}
+
+// datapoint is a single value of a MetricDataResult
+type datapoint struct {
+	value float64
+	t     time.Time
+}
+
+// completeDatapoints returns the values of r that aren't NaN, skipping
+// datapoints whose period ends after complete unless complete is zero, and
+// datapoints without a timestamp.
+func completeDatapoints(r *cloudwatch.MetricDataResult, period time.Duration, complete time.Time) []datapoint {
+	if len(r.Timestamps) != len(r.Values) {
+		return nil
+	}
+
+	var datapoints []datapoint
+	for i, v := range r.Values {
+		t := *r.Timestamps[i]
+		if math.IsNaN(*v) || (!complete.IsZero() && t.Add(period).After(complete)) {
+			continue
+		}
+		datapoints = append(datapoints, datapoint{value: *v, t: t})
+	}
+
+	return datapoints
+}
+
+func getMetricData(b batch, unrolled map[string]MetricStat, seen *seenSet) error {
 	gmdi := &cloudwatch.GetMetricDataInput{
-		StartTime: aws.Time(start),
-		EndTime:   aws.Time(end),
+		StartTime: aws.Time(b.start),
+		EndTime:   aws.Time(b.end),
+		ScanBy:    aws.String(cloudwatch.ScanByTimestampDescending),
 
-		MetricDataQueries: mdq,
+		MetricDataQueries: b.queries,
 		NextToken:         nil,
 	}
 
+	// the datapoints of accumulators are added oldest first once every page
+	// has been read
+	pending := make(map[string][]datapoint)
+
 	for {
-		gmdo, err := cw.GetMetricData(gmdi)
+		gmdo, err := b.client.CloudWatch.GetMetricData(gmdi)
 		if err != nil {
 			return errors.Wrap(err, "cloudwatch.GetMetricData")
 		}
@@ -60,10 +253,35 @@ func getMetricData(cw MetricDataGetter, start, end time.Time, mdq []*cloudwatch.
 			}
 		}
 
+		// the datapoints of a metric may be split across pages, so only
+		// values newer than what was already read are kept
 		for _, v := range gmdo.MetricDataResults {
-			if len(v.Values) != 0 {
-				unrolled[*v.Id].gauge.Set(*v.Values[0])
-				seen[*v.Id] = struct{}{}
+			if ms, ok := unrolled[*v.Id]; ok {
+				if _, ok := ms.gauge.(accumulator); ok {
+					pending[*v.Id] = append(pending[*v.Id], completeDatapoints(v, b.period, b.complete)...)
+					continue
+				}
+			}
+
+			value, t, ok := latestDatapoint(v, b.period, b.complete)
+			if !ok || !seen.newer(*v.Id, t) {
+				continue
+			}
+
+			ms, ok := unrolled[*v.Id]
+			if !ok {
+				continue
+			}
+
+			if ms.scale != 0 {
+				value *= ms.scale
+			}
+
+			g := ms.gauge
+			if tg, ok := g.(timestampedGauge); ok && !t.IsZero() {
+				tg.SetWithTimestamp(value, t)
+			} else {
+				g.Set(value)
 			}
 		}
 
@@ -74,45 +292,148 @@ func getMetricData(cw MetricDataGetter, start, end time.Time, mdq []*cloudwatch.
 		}
 	}
 
+	for id, datapoints := range pending {
+		ms := unrolled[id]
+		acc := ms.gauge.(accumulator)
+
+		sort.Slice(datapoints, func(i, j int) bool { return datapoints[i].t.Before(datapoints[j].t) })
+		for _, d := range datapoints {
+			// what was added before the range read is forgotten below
+			if d.t.Before(b.start) {
+				continue
+			}
+			if ms.scale != 0 {
+				d.value *= ms.scale
+			}
+			acc.add(d.value, d.t)
+			seen.newer(id, d.t)
+		}
+		acc.forget(b.start)
+	}
+
 	return nil
 }
 
-// ReadMetrics pulls metrics for the passed time over the period of duration
-// into the metricstats map.
-func ReadMetrics(cw MetricDataGetter, start time.Time, period time.Duration, metricstats map[string]MetricStat) error {
-	end := start.Add(period)
+// batch is a set of queries of a single namespace and window read with one
+// (paginated) GetMetricData call
+type batch struct {
+	client     Client
+	namespace  string
+	start, end time.Time
+	period     time.Duration
+	queries    []*cloudwatch.MetricDataQuery
+
+	// complete is when the periods of the datapoints read must have ended by,
+	// or zero to read the latest datapoint even if it is incomplete
+	complete time.Time
+}
 
-	seen := make(map[string]struct{}, len(metricstats))
-	mdq := make([]*cloudwatch.MetricDataQuery, 0, 100)
-	for k, v := range metricstats {
-		mdq = append(mdq, &cloudwatch.MetricDataQuery{
-			Id: aws.String(k),
-			MetricStat: &cloudwatch.MetricStat{
-				Metric: v.cloudwatchMetric,
-				Period: aws.Int64(int64(period / time.Second)),
-				Stat:   aws.String(v.statistic),
-			},
-			ReturnData: aws.Bool(true),
-		})
+// batchKey groups the queries that can be read in the same batch
+type batchKey struct {
+	namespace string
+	window    window
+}
 
-		if len(mdq) == 100 {
-			if err := getMetricData(cw, start, end, mdq, metricstats, seen); err != nil {
-				return err
+// makeBatches splits metricstats into batches of up to 100 queries for the
+// client, namespace and window of each MetricStat.
+func makeBatches(clients []Client, now time.Time, metricstats map[string]MetricStat) []batch {
+	var batches []batch
+	for _, c := range clients {
+		byKey := make(map[batchKey][]string)
+		for k, v := range metricstats {
+			if !v.readBy(c) {
+				continue
 			}
 
-			mdq = make([]*cloudwatch.MetricDataQuery, 0, 100)
+			key := batchKey{namespace: v.namespace(), window: v.window.withDefaults()}
+			byKey[key] = append(byKey[key], k)
+		}
+
+		for key, ids := range byKey {
+			b := batch{client: c, namespace: key.namespace, period: key.window.period}
+			b.start, b.end = key.window.bounds(now)
+			if !key.window.includeIncomplete {
+				b.complete = now.Add(-key.window.delay)
+			}
+
+			// the inputs of expressions are named in their config, so
+			// expressions with the same input names go in separate batches
+			inBatch := make(map[string]bool)
+			for _, id := range ids {
+				mdq := metricstats[id].queries(id, key.window.period)
+
+				var collides bool
+				for _, q := range mdq {
+					collides = collides || inBatch[*q.Id]
+				}
+				if len(b.queries) != 0 && (collides || len(b.queries)+len(mdq) > 100) {
+					batches = append(batches, b)
+					b.queries = nil
+					inBatch = make(map[string]bool)
+				}
+
+				for _, q := range mdq {
+					inBatch[*q.Id] = true
+				}
+				b.queries = append(b.queries, mdq...)
+			}
+			batches = append(batches, b)
 		}
 	}
 
-	if len(mdq) != 0 {
-		if err := getMetricData(cw, start, end, mdq, metricstats, seen); err != nil {
-			return err
+	return batches
+}
+
+// ReadMetrics pulls the latest values of metrics, as of now, into the
+// metricstats map.  Each MetricStat is read with the client for its account
+// and region, over the window of its ExportConfig.
+//
+// Metrics are read in batches, up to concurrency at a time; the metrics of a
+// batch that fails are handled according to their StatDefault and the failure
+// is logged and counted.  An error is only returned if every batch failed.
+func ReadMetrics(clients []Client, now time.Time, metricstats map[string]MetricStat, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	seen := &seenSet{ids: make(map[string]time.Time, len(metricstats))}
+	batches := makeBatches(clients, now, metricstats)
+	errs := make([]error, len(batches))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(batches); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				b := batches[i]
+				if err := getMetricData(b, metricstats, seen); err != nil {
+					errs[i] = errors.Wrap(err, "account="+b.client.AccountID+" region="+b.client.Region+" namespace="+b.namespace)
+					getMetricDataBatchErrors.WithLabelValues(b.namespace).Inc()
+					log.Print(errs[i])
+				}
+			}
+		}()
+	}
+	for i := range batches {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var failed int
+	var err error
+	for _, berr := range errs {
+		if berr != nil {
+			failed++
+			err = berr
 		}
 	}
 
 	// set default values for stat according to config for stats no longer seen
 	for k, ms := range metricstats {
-		if _, ok := seen[k]; ok {
+		if _, ok := seen.ids[k]; ok {
 			continue
 		}
 		if ms.statDefault == Zero {
@@ -122,18 +443,23 @@ func ReadMetrics(cw MetricDataGetter, start time.Time, period time.Duration, met
 		}
 	}
 
+	if failed != 0 && failed == len(batches) {
+		return errors.Wrap(err, "every GetMetricData batch failed")
+	}
+
 	return nil
 }
 
 // MetricsToRead returns a map of MetricStats that match the criteria expressed
-// in the ExportConfigs.
-func MetricsToRead(ec []ExportConfig, cw *cloudwatch.CloudWatch) (map[string]MetricStat, error) {
-	ms, err := metricsToRead(ec, cw)
+// in the ExportConfigs, listed in each account and region the ExportConfig
+// applies to, and of the ExpressionConfigs.
+func MetricsToRead(ec []ExportConfig, xc []ExpressionConfig, clients []Client) (map[string]MetricStat, error) {
+	ms, err := metricsToRead(ec, clients)
 	if err != nil {
 		return nil, err
 	}
 
-	return unrollMetrics(ms), nil
+	return unrollMetrics(append(ms, expressionsToRead(xc, clients)...)), nil
 }
 
 type sortableDimensions []*cloudwatch.Dimension
@@ -144,52 +470,259 @@ func (s sortableDimensions) Less(i, j int) bool { return *s[i].Name < *s[j].Name
 
 func (s sortableDimensions) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
-func metricsToRead(ec []ExportConfig, cw *cloudwatch.CloudWatch) ([]MetricStat, error) {
+// dimensionsString returns the dimensions of metric, which must be sorted, as
+// Name=Value pairs
+func dimensionsString(metric *cloudwatch.Metric) string {
+	pairs := make([]string, len(metric.Dimensions))
+	for i, d := range metric.Dimensions {
+		pairs[i] = aws.StringValue(d.Name) + "=" + aws.StringValue(d.Value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// duplicates picks which of the metrics of an ExportConfig with
+// ExtraDimensions that would be exported as the same series is read: the one
+// with the fewest dimensions, which has no extra ones if it was listed, and
+// then the one whose dimensions sort first, so that the same metric is picked
+// whatever order they are listed in.
+type duplicates struct {
+	keys    []string
+	picked  map[string][]MetricStat
+	dropped map[string][]*cloudwatch.Metric
+}
+
+// add adds the MetricStats of metric, whose series is called key
+func (d *duplicates) add(key string, stats []MetricStat, metric *cloudwatch.Metric) {
+	if d.picked == nil {
+		d.picked = make(map[string][]MetricStat)
+		d.dropped = make(map[string][]*cloudwatch.Metric)
+	}
+
+	prior, ok := d.picked[key]
+	if !ok {
+		d.keys = append(d.keys, key)
+		d.picked[key] = stats
+		return
+	}
+
+	if priorMetric := prior[0].cloudwatchMetric; len(metric.Dimensions) < len(priorMetric.Dimensions) ||
+		len(metric.Dimensions) == len(priorMetric.Dimensions) && dimensionsString(metric) < dimensionsString(priorMetric) {
+		d.picked[key] = stats
+		metric = priorMetric
+	}
+	d.dropped[key] = append(d.dropped[key], metric)
+}
+
+// metricStats returns the MetricStats picked for each series; the metrics
+// dropped are logged the first time they are
+func (d *duplicates) metricStats(e ExportConfig, c Client) []MetricStat {
 	var metrics []MetricStat
+	for _, key := range d.keys {
+		stats := d.picked[key]
+		for _, metric := range d.dropped[key] {
+			if e.dropped.add(c.AccountID + "\xff" + c.Region + "\xff" + *metric.MetricName + "\xff" + dimensionsString(metric)) {
+				log.Printf("account=%s region=%s namespace=%s: dropping the %s of %s, whose labels %v duplicate those of %s", c.AccountID, c.Region, e.Namespace, *metric.MetricName, dimensionsString(metric), stats[0].labelValues, dimensionsString(stats[0].cloudwatchMetric))
+			}
+		}
+		metrics = append(metrics, stats...)
+	}
 
+	return metrics
+}
+
+func metricsToRead(ec []ExportConfig, clients []Client) ([]MetricStat, error) {
+	var metrics []MetricStat
+
+	// a resource is exported on aws_resource_info by the first config that
+	// finds it
+	lookup := resourceLookup{}
+	infos := make(map[*resourceInfoCollector]map[string]resourceInfoSeries)
+	claimed := make(map[string]bool)
 	for _, exportConfig := range ec {
-		lmi := &cloudwatch.ListMetricsInput{
-			MetricName: aws.String(exportConfig.Name),
-			Namespace:  aws.String(exportConfig.Namespace),
-		}
-		for {
-			lmo, err := cw.ListMetrics(lmi)
+		for _, c := range clients {
+			if !exportConfig.includesClient(c) {
+				continue
+			}
+
+			// with ExtraDimensions, metrics that differ only in the ignored
+			// dimensions would be exported as the same series
+			var (
+				groups aggregates
+				dups   duplicates
+			)
+
+			var (
+				found []*cloudwatch.Metric
+				err   error
+			)
+			if exportConfig.Search {
+				found, err = searchMetrics(exportConfig, c, time.Now())
+			} else {
+				found, err = listMetrics(exportConfig, c)
+			}
 			if err != nil {
-				return nil, errors.Wrap(err, "cloudwatch.ListMetrics")
+				return nil, errors.Wrap(err, "region="+c.Region)
 			}
 
-			for _, metric := range lmo.Metrics {
-				if !includeMetric(exportConfig, metric) {
+			// without tags the metrics can't be filtered by them, so the
+			// refresh fails; otherwise they are exported untagged
+			var resources map[string]taggedResource
+			if exportConfig.tagged() {
+				resources, err = lookup.resources(c, exportConfig.resource)
+				if err != nil && exportConfig.filtersTags() {
+					return nil, errors.Wrap(err, "region="+c.Region)
+				} else if err != nil {
+					log.Printf("account=%s region=%s namespace=%s: %s", c.AccountID, c.Region, exportConfig.Namespace, err)
+				}
+			}
+
+			for _, metric := range found {
+				// names that can't be exported were logged when found
+				collectors, err := exportConfig.collectorsFor(*metric.MetricName)
+				if err != nil {
 					continue
 				}
-				sort.Sort(sortableDimensions(metric.Dimensions))
 
-				for i, s := range exportConfig.Statistics {
-					values := make([]string, 0, len(metric.Dimensions))
-					for _, v := range metric.Dimensions {
-						values = append(values, *v.Value)
+				var tags []string
+				if exportConfig.tagged() {
+					name := dimensionValue(metric, exportConfig.resource.dimension)
+					r := resources[name]
+					if !includeTags(exportConfig, r.tags) {
+						continue
+					}
+					tags = exportConfig.tagValues(r)
+
+					label := exportConfig.labelName(exportConfig.resource.dimension)
+					if key := r.arn + "\xff" + label; exportConfig.ResourceInfo && r.arn != "" && !claimed[key] {
+						claimed[key] = true
+						if infos[exportConfig.info] == nil {
+							infos[exportConfig.info] = make(map[string]resourceInfoSeries)
+						}
+						infos[exportConfig.info][key] = exportConfig.info.newSeries(c, label, name, r)
 					}
+				}
 
-					metrics = append(metrics, MetricStat{
-						statistic:        s,
-						cloudwatchMetric: metric,
-						gauge:            exportConfig.collectors[i].WithLabelValues(values...),
-						statDefault:      exportConfig.StatDefault,
-					})
+				stats := metricStats(exportConfig, c, metric, collectors, tags)
+				key := *metric.MetricName + "\xff" + strings.Join(stats[0].labelValues, "\xff")
+				if exportConfig.aggregated() {
+					groups.add(exportConfig, key, stats, metric)
+					continue
+				}
+				if exportConfig.ExtraDimensions {
+					dups.add(key, stats, metric)
+					continue
 				}
+
+				metrics = append(metrics, stats...)
 			}
+			metrics = append(metrics, dups.metricStats(exportConfig, c)...)
+			metrics = append(metrics, groups.metricStats(c)...)
+		}
+	}
 
-			if lmo.NextToken != nil {
-				lmi.NextToken = lmo.NextToken
-			} else {
-				break
+	for _, exportConfig := range ec {
+		if exportConfig.info == nil {
+			continue
+		}
+
+		found := infos[exportConfig.info]
+		keys := make([]string, 0, len(found))
+		for k := range found {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		series := make([]resourceInfoSeries, len(keys))
+		for i, k := range keys {
+			series[i] = found[k]
+		}
+		exportConfig.info.set(series)
+	}
+
+	return metrics, nil
+}
+
+// dimensionValue returns the value of the dimension of metric called name
+func dimensionValue(metric *cloudwatch.Metric, name string) string {
+	for _, d := range metric.Dimensions {
+		if aws.StringValue(d.Name) == name {
+			return aws.StringValue(d.Value)
+		}
+	}
+
+	return ""
+}
+
+// listMetrics returns the metrics of exportConfig that CloudWatch lists for c
+func listMetrics(exportConfig ExportConfig, c Client) ([]*cloudwatch.Metric, error) {
+	var metrics []*cloudwatch.Metric
+
+	lmi := &cloudwatch.ListMetricsInput{
+		Namespace: aws.String(exportConfig.Namespace),
+	}
+	if !exportConfig.matchesNames() {
+		lmi.MetricName = aws.String(exportConfig.Name)
+	}
+	for {
+		lmo, err := c.CloudWatch.ListMetrics(lmi)
+		if err != nil {
+			return nil, errors.Wrap(err, "cloudwatch.ListMetrics")
+		}
+
+		for _, metric := range lmo.Metrics {
+			if includeMetric(exportConfig, metric) {
+				metrics = append(metrics, metric)
 			}
 		}
+
+		if lmo.NextToken != nil {
+			lmi.NextToken = lmo.NextToken
+		} else {
+			break
+		}
 	}
 
 	return metrics, nil
 }
 
+// metricStats returns a MetricStat for each of the statistics of exportConfig
+// of metric, read by c into collectors, with the values of its TagLabels
+func metricStats(exportConfig ExportConfig, c Client, metric *cloudwatch.Metric, collectors []gaugeVec, tags []string) []MetricStat {
+	sort.Sort(sortableDimensions(metric.Dimensions))
+
+	metrics := make([]MetricStat, 0, len(exportConfig.Statistics))
+	dimensions := exportConfig.labeledDimensions()
+	for i, s := range exportConfig.Statistics {
+		values := make([]string, 0, len(dimensions)+len(exportConfig.LabelTemplates)+len(tags)+3)
+		values = append(values, c.AccountID, c.Region)
+		for _, d := range dimensions {
+			values = append(values, dimensionValue(metric, d))
+		}
+		values = append(values, exportConfig.templatedValues(metric)...)
+		values = append(values, tags...)
+		if exportConfig.hasQuantile(i) {
+			values = append(values, quantile(s))
+		}
+
+		metrics = append(metrics, MetricStat{
+			statistic:        s,
+			cloudwatchMetric: metric,
+			gauge:            collectors[i].with(values...),
+			statDefault:      exportConfig.StatDefault,
+			region:           c.Region,
+			accountID:        c.AccountID,
+			window:           exportConfig.window(),
+			unit:             exportConfig.Unit,
+			scale:            exportConfig.scale(i),
+			collector:        collectors[i],
+			labelValues:      values,
+			gracePeriod:      exportConfig.GracePeriod,
+		})
+	}
+
+	return metrics
+}
+
 func unrollMetrics(ms []MetricStat) map[string]MetricStat {
 	ret := make(map[string]MetricStat, len(ms))
 
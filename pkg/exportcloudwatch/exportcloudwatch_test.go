@@ -1,27 +1,30 @@
 package exportcloudwatch
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestReadMetrics(t *testing.T) {
 	scw := stubCloudWatch{}
-	period := 5 * time.Minute
-	start := time.Now().Add(-2 * period).Truncate(period)
 	metricstats := newMetricstats()
 
-	err := ReadMetrics(scw, start, period, metricstats)
+	err := ReadMetrics([]Client{{CloudWatch: scw}}, time.Now(), metricstats, 1)
 
 	assert.NoError(t, err)
 	for _, ms := range metricstats {
@@ -44,6 +47,429 @@ func TestReadMetrics(t *testing.T) {
 	}
 }
 
+func TestReadMetricsClients(t *testing.T) {
+	east := &recordingCloudWatch{}
+	west := &recordingCloudWatch{}
+	other := &recordingCloudWatch{}
+	clients := []Client{
+		{Region: "us-east-1", AccountID: "111111111111", CloudWatch: east},
+		{Region: "us-west-2", AccountID: "111111111111", CloudWatch: west},
+		{Region: "us-east-1", AccountID: "222222222222", CloudWatch: other},
+	}
+	metricstats := map[string]MetricStat{
+		"i0": {statistic: "Sum", gauge: &mockGauge{}, region: "us-east-1", accountID: "111111111111"},
+		"i1": {statistic: "Sum", gauge: &mockGauge{}, region: "us-west-2", accountID: "111111111111"},
+		"i2": {statistic: "Sum", gauge: &mockGauge{}, region: "us-west-2", accountID: "111111111111"},
+		"i3": {statistic: "Sum", gauge: &mockGauge{}, region: "us-east-1", accountID: "222222222222"},
+	}
+
+	err := ReadMetrics(clients, time.Now(), metricstats, 2)
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"i0"}, east.ids)
+	assert.ElementsMatch(t, []string{"i1", "i2"}, west.ids)
+	assert.ElementsMatch(t, []string{"i3"}, other.ids)
+	for k, ms := range metricstats {
+		assert.NotNil(t, ms.gauge.(*mockGauge).value, "Gauge for %s was set", k)
+	}
+}
+
+func TestReadMetricsConcurrency(t *testing.T) {
+	rcw := &recordingCloudWatch{barrier: 3}
+	metricstats := make(map[string]MetricStat, 450)
+	for i := 0; i < 450; i++ {
+		metricstats[fmt.Sprintf("i%d", i)] = MetricStat{statistic: "Sum", gauge: &mockGauge{}}
+	}
+
+	err := ReadMetrics([]Client{{CloudWatch: rcw}}, time.Now(), metricstats, 3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 450, len(rcw.ids), "every metric was read once")
+	assert.Equal(t, 3, rcw.maxInFlight, "batches were read concurrently, and no more than concurrency at a time")
+	for k, ms := range metricstats {
+		assert.NotNil(t, ms.gauge.(*mockGauge).value, "Gauge for %s was set", k)
+	}
+}
+
+func TestMakeBatchesWindows(t *testing.T) {
+	now := time.Date(2020, 8, 1, 12, 34, 56, 0, time.UTC)
+	daily := window{period: 24 * time.Hour, delay: time.Hour, delaySet: true, rng: 48 * time.Hour}
+	metricstats := map[string]MetricStat{
+		"i0": {statistic: "Sum"},
+		"i1": {statistic: "Sum", window: window{period: time.Minute}},
+		"i2": {statistic: "Sum", window: daily},
+	}
+
+	batches := makeBatches([]Client{{}}, now, metricstats)
+
+	type got struct {
+		start, end time.Time
+		period     int64
+		ids        []string
+	}
+	var gots []got
+	for _, b := range batches {
+		g := got{start: b.start, end: b.end, period: *b.queries[0].MetricStat.Period}
+		for _, q := range b.queries {
+			g.ids = append(g.ids, *q.Id)
+		}
+		sort.Strings(g.ids)
+		gots = append(gots, g)
+	}
+	sort.Slice(gots, func(i, j int) bool { return gots[i].period < gots[j].period })
+
+	assert.Equal(t, []got{{
+		start:  time.Date(2020, 8, 1, 12, 29, 0, 0, time.UTC),
+		end:    time.Date(2020, 8, 1, 12, 34, 0, 0, time.UTC),
+		period: 60,
+		ids:    []string{"i0", "i1"},
+	}, {
+		start:  time.Date(2020, 7, 31, 0, 0, 0, 0, time.UTC),
+		end:    time.Date(2020, 8, 2, 0, 0, 0, 0, time.UTC),
+		period: 86400,
+		ids:    []string{"i2"},
+	}}, gots)
+}
+
+type latestDatapointTest struct {
+	name string
+
+	values     []float64
+	timestamps []time.Time
+	complete   time.Time
+
+	value     float64
+	timestamp time.Time
+	ok        bool
+}
+
+func TestLatestDatapoint(t *testing.T) {
+	t0 := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	t2 := t1.Add(time.Minute)
+
+	tests := []latestDatapointTest{
+		{
+			name: "empty",
+		},
+		{
+			name:       "descending",
+			values:     []float64{3, 2, 1},
+			timestamps: []time.Time{t2, t1, t0},
+			value:      3,
+			timestamp:  t2,
+			ok:         true,
+		},
+		{
+			name:       "ascending",
+			values:     []float64{1, 2, 3},
+			timestamps: []time.Time{t0, t1, t2},
+			value:      3,
+			timestamp:  t2,
+			ok:         true,
+		},
+		{
+			name:       "NaN",
+			values:     []float64{math.NaN(), 2, 1},
+			timestamps: []time.Time{t2, t1, t0},
+			value:      2,
+			timestamp:  t1,
+			ok:         true,
+		},
+		{
+			name:       "incomplete",
+			values:     []float64{3, 2, 1},
+			timestamps: []time.Time{t2, t1, t0},
+			complete:   t2.Add(30 * time.Second),
+			value:      2,
+			timestamp:  t1,
+			ok:         true,
+		},
+		{
+			name:       "all incomplete",
+			values:     []float64{3},
+			timestamps: []time.Time{t2},
+			complete:   t2,
+		},
+		{
+			name:   "no timestamps",
+			values: []float64{3, 2},
+			value:  3,
+			ok:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &cloudwatch.MetricDataResult{
+				Values:     aws.Float64Slice(test.values),
+				Timestamps: aws.TimeSlice(test.timestamps),
+			}
+
+			value, timestamp, ok := latestDatapoint(r, time.Minute, test.complete)
+
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.value, value)
+			assert.Equal(t, test.timestamp, timestamp)
+		})
+	}
+}
+
+func TestGetMetricDataPages(t *testing.T) {
+	t0 := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	pcw := pagedCloudWatch{pages: []*cloudwatch.GetMetricDataOutput{{
+		MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         aws.String("i0"),
+			Values:     aws.Float64Slice([]float64{2}),
+			Timestamps: aws.TimeSlice([]time.Time{t1}),
+		}},
+	}, {
+		MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         aws.String("i0"),
+			Values:     aws.Float64Slice([]float64{1}),
+			Timestamps: aws.TimeSlice([]time.Time{t0}),
+		}},
+	}}}
+	metricstats := map[string]MetricStat{"i0": {statistic: "Sum", gauge: &mockGauge{}}}
+
+	b := batch{client: Client{CloudWatch: &pcw}, period: time.Minute}
+	err := getMetricData(b, metricstats, &seenSet{ids: map[string]time.Time{}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, cloudwatch.ScanByTimestampDescending, *pcw.inputs[0].ScanBy)
+	assert.Equal(t, float64(2), *metricstats["i0"].gauge.(*mockGauge).value, "the older datapoint on the later page was ignored")
+}
+
+func TestReadMetricsPartialFailure(t *testing.T) {
+	clients := []Client{{CloudWatch: failingCloudWatch{namespace: "AWS/Bad"}}}
+	stat := func(namespace string) MetricStat {
+		return MetricStat{
+			statistic:        "Sum",
+			cloudwatchMetric: &cloudwatch.Metric{Namespace: aws.String(namespace)},
+			gauge:            &mockGauge{},
+			statDefault:      Zero,
+		}
+	}
+	metricstats := make(map[string]MetricStat, 250)
+	for i := 0; i < 150; i++ {
+		metricstats[fmt.Sprintf("i%d", i)] = stat("AWS/Good")
+	}
+	for i := 150; i < 250; i++ {
+		metricstats[fmt.Sprintf("i%d", i)] = stat("AWS/Bad")
+	}
+	failures := testutil.ToFloat64(getMetricDataBatchErrors.WithLabelValues("AWS/Bad"))
+
+	err := ReadMetrics(clients, time.Now(), metricstats, 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, failures+1, testutil.ToFloat64(getMetricDataBatchErrors.WithLabelValues("AWS/Bad")))
+	for k, ms := range metricstats {
+		value := ms.gauge.(*mockGauge).value
+		if assert.NotNil(t, value, "Gauge for %s was set", k) {
+			if ms.namespace() == "AWS/Bad" {
+				assert.Equal(t, float64(0), *value, "Gauge for %s of failed batch was reset to zero", k)
+			} else {
+				assert.Equal(t, float64(1), *value, "Gauge for %s was read", k)
+			}
+		}
+	}
+
+	bad := map[string]MetricStat{"i0": stat("AWS/Bad")}
+	assert.Error(t, ReadMetrics(clients, time.Now(), bad, 2), "every batch failed")
+}
+
+func TestMetricsToReadRegions(t *testing.T) {
+	clients := []Client{
+		{Region: "us-east-1", CloudWatch: stubCloudWatch{metrics: []*cloudwatch.Metric{newMetric("AWS/SQS", "TestMetricsToReadRegions", "QueueName", "east")}}},
+		{Region: "us-west-2", CloudWatch: stubCloudWatch{metrics: []*cloudwatch.Metric{newMetric("AWS/SQS", "TestMetricsToReadRegions", "QueueName", "west")}}},
+	}
+
+	all := ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "TestMetricsToReadRegions",
+		Dimensions: []string{"QueueName"},
+		Statistics: []string{"Sum"},
+	}
+	west := ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "TestMetricsToReadRegions",
+		Dimensions: []string{"QueueName"},
+		Statistics: []string{"Maximum"},
+		Regions:    []string{"us-west-2"},
+	}
+	assert.NoError(t, all.Validate())
+	assert.NoError(t, west.Validate())
+
+	ms, err := metricsToRead([]ExportConfig{all, west}, clients)
+	assert.NoError(t, err)
+
+	got := make([]string, 0, len(ms))
+	for _, m := range ms {
+		got = append(got, m.region+" "+*m.cloudwatchMetric.Dimensions[0].Value+" "+m.statistic)
+	}
+	assert.Equal(t, []string{
+		"us-east-1 east Sum",
+		"us-west-2 west Sum",
+		"us-west-2 west Maximum",
+	}, got)
+
+	// the same queue name in each region is a separate series
+	assert.Equal(t, 2, testutil.CollectAndCount(all.collectors[0]))
+}
+
+func TestMetricsToReadAccounts(t *testing.T) {
+	metric := &cloudwatch.Metric{
+		Namespace:  aws.String("AWS/SQS"),
+		MetricName: aws.String("TestMetricsToReadAccounts"),
+		Dimensions: []*cloudwatch.Dimension{{
+			Name:  aws.String("QueueName"),
+			Value: aws.String("jobs"),
+		}},
+	}
+	scw := stubCloudWatch{metrics: []*cloudwatch.Metric{metric}}
+	clients := []Client{
+		{Region: "us-east-1", AccountID: "111111111111", CloudWatch: scw},
+		{Region: "us-east-1", AccountID: "222222222222", CloudWatch: scw},
+		{Region: "us-east-1", AccountID: "333333333333", CloudWatch: scw},
+	}
+
+	e := ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "TestMetricsToReadAccounts",
+		Dimensions: []string{"QueueName"},
+		Statistics: []string{"Sum"},
+		Accounts:   []string{"111111111111", "333333333333"},
+	}
+	assert.NoError(t, e.Validate())
+
+	ms, err := metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+
+	got := make([]string, 0, len(ms))
+	for _, m := range ms {
+		got = append(got, m.accountID)
+	}
+	assert.Equal(t, []string{"111111111111", "333333333333"}, got)
+	assert.Equal(t, 2, testutil.CollectAndCount(e.collectors[0]))
+}
+
+func TestMetricsToReadNameMatch(t *testing.T) {
+	scw := stubCloudWatch{metrics: []*cloudwatch.Metric{
+		newMetric("AWS/ApplicationELB", "TestNameMatchRequestCount", "LoadBalancer", "app/web"),
+		newMetric("AWS/ApplicationELB", "TestNameMatchTargetResponseTime", "LoadBalancer", "app/web"),
+		newMetric("AWS/ApplicationELB", "TestNameMatchConsumedLCUs", "LoadBalancer", "app/web"),
+		newMetric("AWS/ApplicationELB", "ActiveConnectionCount", "LoadBalancer", "app/web"),
+	}}
+
+	e := ExportConfig{
+		Namespace:   "AWS/ApplicationELB",
+		NameMatch:   regexp.MustCompile("^TestNameMatch"),
+		NameNoMatch: regexp.MustCompile("LCUs$"),
+		Dimensions:  []string{"LoadBalancer"},
+		Statistics:  []string{"Sum"},
+	}
+	assert.NoError(t, e.Validate())
+	assert.Nil(t, e.collectors, "collectors are registered as names are found")
+
+	// another config already exports one of the names, so only it is skipped
+	taken := ExportConfig{
+		Namespace:  "AWS/ApplicationELB",
+		Name:       "TestNameMatchTargetResponseTime",
+		Dimensions: []string{"TargetGroup"},
+		Statistics: []string{"Sum"},
+	}
+	assert.NoError(t, taken.Validate())
+
+	skipped := testutil.ToFloat64(skippedMetricNames.WithLabelValues("AWS/ApplicationELB"))
+	clients := []Client{{Region: "us-east-1", CloudWatch: scw}}
+	for i := 0; i < 2; i++ {
+		ms, err := metricsToRead([]ExportConfig{e}, clients)
+		assert.NoError(t, err)
+
+		got := make([]string, 0, len(ms))
+		for _, m := range ms {
+			got = append(got, *m.cloudwatchMetric.MetricName)
+		}
+		assert.Equal(t, []string{"TestNameMatchRequestCount"}, got)
+	}
+	assert.Equal(t, skipped+1, testutil.ToFloat64(skippedMetricNames.WithLabelValues("AWS/ApplicationELB")), "a skipped name is counted once")
+
+	collectors, err := e.collectorsFor("TestNameMatchRequestCount")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(collectors[0], "aws_applicationelb_test_name_match_request_count_sum"))
+
+	_, err = e.collectorsFor("TestNameMatchTargetResponseTime")
+	assert.Error(t, err)
+}
+
+func TestMetricsToReadOptionalDimensions(t *testing.T) {
+	scw := stubCloudWatch{metrics: []*cloudwatch.Metric{
+		newMetric("AWS/ApplicationELB", "TestOptionalDimensions", "LoadBalancer", "app/web"),
+		newMetric("AWS/ApplicationELB", "TestOptionalDimensions", "LoadBalancer", "app/web", "AvailabilityZone", "us-east-1a"),
+		newMetric("AWS/ApplicationELB", "TestOptionalDimensions", "LoadBalancer", "app/web", "AvailabilityZone", "us-east-1a", "TargetGroup", "targetgroup/a"),
+		newMetric("AWS/ApplicationELB", "TestOptionalDimensions", "LoadBalancer", "app/web", "AvailabilityZone", "us-east-1a", "TargetGroup", "targetgroup/b"),
+		newMetric("AWS/ApplicationELB", "TestOptionalDimensions", "TargetGroup", "targetgroup/a"),
+	}}
+
+	e := ExportConfig{
+		Namespace:          "AWS/ApplicationELB",
+		Name:               "TestOptionalDimensions",
+		Dimensions:         []string{"LoadBalancer"},
+		OptionalDimensions: []string{"AvailabilityZone"},
+		Statistics:         []string{"Sum"},
+	}
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, []string{accountLabel, regionLabel, "availability_zone", "load_balancer"}, e.labels)
+
+	clients := []Client{{Region: "us-east-1", CloudWatch: scw}}
+	ms, err := metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+
+	var got [][]string
+	for _, m := range ms {
+		got = append(got, m.labelValues)
+	}
+	assert.Equal(t, [][]string{
+		{"", "us-east-1", "", "app/web"},
+		{"", "us-east-1", "us-east-1a", "app/web"},
+	}, got)
+
+	// the TargetGroup is ignored, so the metrics with one duplicate the one
+	// without and are dropped
+	e.ExtraDimensions = true
+	ms, err = metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+
+	got = nil
+	for _, m := range ms {
+		got = append(got, m.labelValues)
+	}
+	assert.Equal(t, [][]string{
+		{"", "us-east-1", "", "app/web"},
+		{"", "us-east-1", "us-east-1a", "app/web"},
+	}, got)
+	assert.Equal(t, "AvailabilityZone=us-east-1a,LoadBalancer=app/web", dimensionsString(ms[1].cloudwatchMetric))
+
+	// without the exact match, whichever order the metrics are listed in the
+	// one whose dimensions sort first is read, and the dropped one, which was
+	// already dropped above, isn't recorded again
+	scw.metrics = []*cloudwatch.Metric{
+		newMetric("AWS/ApplicationELB", "TestOptionalDimensions", "LoadBalancer", "app/web", "AvailabilityZone", "us-east-1a", "TargetGroup", "targetgroup/b"),
+		newMetric("AWS/ApplicationELB", "TestOptionalDimensions", "LoadBalancer", "app/web", "AvailabilityZone", "us-east-1a", "TargetGroup", "targetgroup/a"),
+	}
+	clients = []Client{{Region: "us-east-1", CloudWatch: scw}}
+	for i := 0; i < 2; i++ {
+		ms, err = metricsToRead([]ExportConfig{e}, clients)
+		assert.NoError(t, err)
+		if assert.Len(t, ms, 1) {
+			assert.Equal(t, "AvailabilityZone=us-east-1a,LoadBalancer=app/web,TargetGroup=targetgroup/a", dimensionsString(ms[0].cloudwatchMetric))
+		}
+		scw.metrics[0], scw.metrics[1] = scw.metrics[1], scw.metrics[0]
+	}
+	assert.Len(t, e.dropped.metrics, 2)
+}
+
 type unrollTest struct {
 	name string
 	in   []MetricStat
@@ -208,7 +634,44 @@ func newMetricstats() map[string]MetricStat {
 	return retval
 }
 
-type stubCloudWatch struct{}
+// stubTimestamp is the timestamp of every value stubCloudWatch returns
+var stubTimestamp = time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+
+// newMetric returns the metric called name in namespace, with dimensions
+// given as name and value pairs
+func newMetric(namespace, name string, dimensions ...string) *cloudwatch.Metric {
+	m := &cloudwatch.Metric{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(name),
+	}
+	for i := 0; i < len(dimensions); i += 2 {
+		m.Dimensions = append(m.Dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(dimensions[i]),
+			Value: aws.String(dimensions[i+1]),
+		})
+	}
+
+	return m
+}
+
+type stubCloudWatch struct {
+	metrics []*cloudwatch.Metric
+}
+
+func (scw stubCloudWatch) ListMetrics(lmi *cloudwatch.ListMetricsInput) (*cloudwatch.ListMetricsOutput, error) {
+	if lmi.MetricName == nil {
+		return &cloudwatch.ListMetricsOutput{Metrics: scw.metrics}, nil
+	}
+
+	var metrics []*cloudwatch.Metric
+	for _, m := range scw.metrics {
+		if aws.StringValue(m.MetricName) == *lmi.MetricName {
+			metrics = append(metrics, m)
+		}
+	}
+
+	return &cloudwatch.ListMetricsOutput{Metrics: metrics}, nil
+}
 
 func (scw stubCloudWatch) GetMetricData(gmdi *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
 	// handle in batches of 20 at a time to make sure implementation is properly
@@ -227,12 +690,16 @@ func (scw stubCloudWatch) GetMetricData(gmdi *cloudwatch.GetMetricDataInput) (*c
 
 	for i := start; i < end && i < len(gmdi.MetricDataQueries); i++ {
 		mdq := gmdi.MetricDataQueries[i]
-		if strings.Contains(*mdq.MetricStat.Stat, "-skip") {
+		if mdq.ReturnData != nil && !*mdq.ReturnData {
+			continue
+		}
+		if mdq.MetricStat != nil && strings.Contains(*mdq.MetricStat.Stat, "-skip") {
 			continue
 		}
 		gmdo.MetricDataResults = append(gmdo.MetricDataResults, &cloudwatch.MetricDataResult{
-			Id:     mdq.Id,
-			Values: []*float64{&one},
+			Id:         mdq.Id,
+			Values:     []*float64{&one},
+			Timestamps: []*time.Time{&stubTimestamp},
 		})
 	}
 
@@ -244,6 +711,100 @@ func (scw stubCloudWatch) GetMetricData(gmdi *cloudwatch.GetMetricDataInput) (*c
 	return &gmdo, nil
 }
 
+// recordingCloudWatch returns a value for every query and records the ids it
+// was asked for
+type recordingCloudWatch struct {
+	stubCloudWatch
+
+	mu  sync.Mutex
+	ids []string
+
+	// inFlight and maxInFlight count concurrent calls
+	inFlight, maxInFlight int
+
+	// if barrier is set, calls wait for barrier calls to be in flight at
+	// once, or give up after a while
+	barrier int
+	all     chan struct{}
+}
+
+func (rcw *recordingCloudWatch) GetMetricData(gmdi *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	rcw.mu.Lock()
+	rcw.inFlight++
+	if rcw.inFlight > rcw.maxInFlight {
+		rcw.maxInFlight = rcw.inFlight
+	}
+	if gmdi.NextToken == nil {
+		for _, mdq := range gmdi.MetricDataQueries {
+			rcw.ids = append(rcw.ids, *mdq.Id)
+		}
+	}
+	if rcw.barrier != 0 && rcw.all == nil {
+		rcw.all = make(chan struct{})
+	}
+	all := rcw.all
+	if rcw.barrier != 0 && rcw.inFlight == rcw.barrier {
+		close(rcw.all)
+		rcw.barrier = 0
+	}
+	rcw.mu.Unlock()
+
+	if all != nil {
+		select {
+		case <-all:
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	rcw.mu.Lock()
+	rcw.inFlight--
+	rcw.mu.Unlock()
+
+	return rcw.stubCloudWatch.GetMetricData(gmdi)
+}
+
+// failingCloudWatch fails to get the data of any batch including a metric of
+// namespace
+type failingCloudWatch struct {
+	stubCloudWatch
+
+	namespace string
+}
+
+func (fcw failingCloudWatch) GetMetricData(gmdi *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	for _, mdq := range gmdi.MetricDataQueries {
+		if mdq.MetricStat != nil && *mdq.MetricStat.Metric.Namespace == fcw.namespace {
+			return nil, errors.New("InvalidParameterValue")
+		}
+	}
+
+	return fcw.stubCloudWatch.GetMetricData(gmdi)
+}
+
+// pagedCloudWatch returns each of pages in turn
+type pagedCloudWatch struct {
+	stubCloudWatch
+
+	pages  []*cloudwatch.GetMetricDataOutput
+	inputs []cloudwatch.GetMetricDataInput
+}
+
+func (pcw *pagedCloudWatch) GetMetricData(gmdi *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	pcw.inputs = append(pcw.inputs, *gmdi)
+
+	page := 0
+	if gmdi.NextToken != nil {
+		page, _ = strconv.Atoi(*gmdi.NextToken)
+	}
+
+	gmdo := *pcw.pages[page]
+	if page+1 < len(pcw.pages) {
+		gmdo.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+
+	return &gmdo, nil
+}
+
 type mockGauge struct {
 	value *float64
 }
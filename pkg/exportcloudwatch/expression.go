@@ -0,0 +1,182 @@
+package exportcloudwatch
+
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// expressionNamespace is what expressions are counted as in place of a
+// CloudWatch namespace
+const expressionNamespace = "MetricMath"
+
+var (
+	// queryIDRE is what CloudWatch allows as the id of a query; ids like i0
+	// are used for MetricStats, and ids like i0_1 for the inputs of
+	// aggregates, so they can't name inputs
+	queryIDRE    = regexp.MustCompile("^[a-z][a-zA-Z0-9_]*$")
+	reservedIDRE = regexp.MustCompile("^i[0-9]+(_[0-9]+)?$")
+)
+
+// ExpressionMetric is a CloudWatch metric that is an input to an expression
+type ExpressionMetric struct {
+	Namespace, Name, Statistic string
+
+	Dimensions map[string]string
+}
+
+// ExpressionConfig describes a CloudWatch Metric Math expression to export as
+// a gauge.  Make sure you call Validate.
+type ExpressionConfig struct {
+	// Name and Help describe the exported gauge, which has Labels in addition
+	// to the account_id and region labels
+	Name, Help string
+	Labels     map[string]string
+
+	// Expression is the Metric Math expression, which refers to Metrics by
+	// their keys
+	Expression string
+	Metrics    map[string]ExpressionMetric
+
+	// These are as in ExportConfig
+	StatDefault       StatDefaultType
+	Regions, Accounts []string
+	Period, Range     time.Duration
+	Delay             *time.Duration
+	IncludeIncomplete bool
+	Timestamps        bool
+
+	collector gaugeVec
+}
+
+func (x *ExpressionConfig) window() window {
+	return newWindow(x.Period, x.Delay, x.Range, x.IncludeIncomplete)
+}
+
+// Validate returns an error if the configuration is incorrect and registers
+// the gauge with the default prometheus registry.
+func (x *ExpressionConfig) Validate() error {
+	if !metricNameRE.MatchString(x.Name) {
+		return errors.New("Invalid expression Name " + x.Name)
+	}
+
+	if x.Expression == "" {
+		return errors.New("Expression is required")
+	}
+
+	if len(x.Metrics) == 0 {
+		return errors.New("At least one expression metric is required")
+	}
+
+	for id, m := range x.Metrics {
+		if !queryIDRE.MatchString(id) || reservedIDRE.MatchString(id) {
+			return errors.New("Invalid expression metric id " + id)
+		}
+		if m.Namespace == "" || m.Name == "" || m.Statistic == "" {
+			return errors.New("Expression metric " + id + " needs a Namespace, Name and Statistic")
+		}
+		if !validStatistic(m.Statistic) {
+			return errors.New("Invalid statistic " + m.Statistic)
+		}
+	}
+
+	for k := range x.Labels {
+		if !labelNameRE.MatchString(k) || k == accountLabel || k == regionLabel {
+			return errors.New("Invalid expression label " + k)
+		}
+	}
+
+	if err := x.window().validate(); err != nil {
+		return err
+	}
+
+	opts := prometheus.GaugeOpts{
+		Name:        x.Name,
+		Help:        x.Help,
+		ConstLabels: x.Labels,
+	}
+	labels := []string{accountLabel, regionLabel}
+	if x.Timestamps {
+		x.collector = newTimestampedGaugeVec(opts, labels)
+	} else {
+		x.collector = promGaugeVec{prometheus.NewGaugeVec(opts, labels)}
+	}
+	if err := prometheus.Register(x.collector); err != nil {
+		return errors.Wrap(err, "Expression Name="+x.Name)
+	}
+
+	return nil
+}
+
+// inputs returns the queries of x's metrics, which aren't returned
+func (x *ExpressionConfig) inputs() []*cloudwatch.MetricDataQuery {
+	ids := make([]string, 0, len(x.Metrics))
+	for id := range x.Metrics {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	mdq := make([]*cloudwatch.MetricDataQuery, 0, len(ids))
+	for _, id := range ids {
+		m := x.Metrics[id]
+
+		dimensions := make([]*cloudwatch.Dimension, 0, len(m.Dimensions))
+		for k, v := range m.Dimensions {
+			dimensions = append(dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(k),
+				Value: aws.String(v),
+			})
+		}
+		sort.Sort(sortableDimensions(dimensions))
+
+		mdq = append(mdq, &cloudwatch.MetricDataQuery{
+			Id: aws.String(id),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String(m.Namespace),
+					MetricName: aws.String(m.Name),
+					Dimensions: dimensions,
+				},
+				Stat: aws.String(m.Statistic),
+			},
+			ReturnData: aws.Bool(false),
+		})
+	}
+
+	return mdq
+}
+
+// expressionsToRead returns a MetricStat for each expression in each account
+// and region it applies to.
+func expressionsToRead(xc []ExpressionConfig, clients []Client) []MetricStat {
+	var metrics []MetricStat
+
+	for _, x := range xc {
+		inputs := x.inputs()
+		for _, c := range clients {
+			if !includesClient(x.Regions, x.Accounts, c) {
+				continue
+			}
+
+			values := []string{c.AccountID, c.Region}
+			metrics = append(metrics, MetricStat{
+				gauge:       x.collector.with(values...),
+				statDefault: x.StatDefault,
+				region:      c.Region,
+				accountID:   c.AccountID,
+				window:      x.window(),
+				expression:  x.Expression,
+				inputs:      inputs,
+				collector:   x.collector,
+				labelValues: values,
+			})
+		}
+	}
+
+	return metrics
+}
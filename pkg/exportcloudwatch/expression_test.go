@@ -0,0 +1,139 @@
+package exportcloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressionConfigValidate(t *testing.T) {
+	valid := func() ExpressionConfig {
+		return ExpressionConfig{
+			Name:       "test_expression_error_rate",
+			Expression: "errors / invocations",
+			Metrics: map[string]ExpressionMetric{
+				"errors":      {Namespace: "AWS/Lambda", Name: "Errors", Statistic: "Sum"},
+				"invocations": {Namespace: "AWS/Lambda", Name: "Invocations", Statistic: "Sum"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*ExpressionConfig)
+		err    string
+	}{
+		{"Name", func(x *ExpressionConfig) { x.Name = "error-rate" }, "Invalid expression Name error-rate"},
+		{"Expression", func(x *ExpressionConfig) { x.Expression = "" }, "Expression is required"},
+		{"No Metrics", func(x *ExpressionConfig) { x.Metrics = nil }, "At least one expression metric is required"},
+		{"Reserved ID", func(x *ExpressionConfig) {
+			x.Metrics["i0"] = ExpressionMetric{Namespace: "AWS/Lambda", Name: "Throttles", Statistic: "Sum"}
+		}, "Invalid expression metric id i0"},
+		{"Reserved Aggregate ID", func(x *ExpressionConfig) {
+			x.Metrics["i0_1"] = ExpressionMetric{Namespace: "AWS/Lambda", Name: "Throttles", Statistic: "Sum"}
+		}, "Invalid expression metric id i0_1"},
+		{"Incomplete Metric", func(x *ExpressionConfig) {
+			x.Metrics["throttles"] = ExpressionMetric{Namespace: "AWS/Lambda", Name: "Throttles"}
+		}, "Expression metric throttles needs a Namespace, Name and Statistic"},
+		{"Region Label", func(x *ExpressionConfig) { x.Labels = map[string]string{"region": "x"} }, "Invalid expression label region"},
+		{"Period", func(x *ExpressionConfig) { x.Period = 7 * time.Second }, "Period must be 1, 5, 10, 30 or a multiple of 60 seconds"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			x := valid()
+			test.modify(&x)
+			assert.EqualError(t, x.Validate(), test.err)
+		})
+	}
+
+	x := valid()
+	assert.NoError(t, x.Validate())
+	defer prometheus.Unregister(x.collector)
+}
+
+func TestExpressionsToRead(t *testing.T) {
+	x := ExpressionConfig{
+		Name:       "test_expressions_to_read",
+		Expression: "errors / invocations",
+		Metrics: map[string]ExpressionMetric{
+			"invocations": {Namespace: "AWS/Lambda", Name: "Invocations", Statistic: "Sum"},
+			"errors": {
+				Namespace:  "AWS/Lambda",
+				Name:       "Errors",
+				Statistic:  "Sum",
+				Dimensions: map[string]string{"FunctionName": "f"},
+			},
+		},
+		Regions: []string{"us-west-2"},
+	}
+	assert.NoError(t, x.Validate())
+	defer prometheus.Unregister(x.collector)
+
+	clients := []Client{{Region: "us-east-1"}, {Region: "us-west-2"}}
+	metrics := expressionsToRead([]ExpressionConfig{x}, clients)
+	if !assert.Len(t, metrics, 1) {
+		return
+	}
+	assert.Equal(t, "us-west-2", metrics[0].region)
+	assert.Equal(t, expressionNamespace, metrics[0].namespace())
+
+	mdq := metrics[0].queries("i0", time.Minute)
+	if !assert.Len(t, mdq, 3) {
+		return
+	}
+	assert.Equal(t, "errors", *mdq[0].Id)
+	assert.Equal(t, "FunctionName", *mdq[0].MetricStat.Metric.Dimensions[0].Name)
+	assert.False(t, *mdq[0].ReturnData)
+	assert.Equal(t, "invocations", *mdq[1].Id)
+	assert.Equal(t, "i0", *mdq[2].Id)
+	assert.Equal(t, "errors / invocations", *mdq[2].Expression)
+	assert.True(t, *mdq[2].ReturnData)
+	assert.Equal(t, int64(60), *mdq[2].Period)
+}
+
+func TestMakeBatchesExpressions(t *testing.T) {
+	x := ExpressionConfig{
+		Expression: "m1 * 2",
+		Metrics:    map[string]ExpressionMetric{"m1": {Namespace: "AWS/SQS", Name: "NumberOfMessagesSent", Statistic: "Sum"}},
+	}
+	metricstats := map[string]MetricStat{
+		"i0": {expression: x.Expression, inputs: x.inputs()},
+		"i1": {expression: x.Expression, inputs: x.inputs()},
+	}
+
+	// both expressions name their input m1, so they can't share a batch
+	batches := makeBatches([]Client{{}}, time.Now(), metricstats)
+	if !assert.Len(t, batches, 2) {
+		return
+	}
+	for _, b := range batches {
+		assert.Equal(t, expressionNamespace, b.namespace)
+		assert.Len(t, b.queries, 2)
+	}
+}
+
+func TestReadMetricsExpressions(t *testing.T) {
+	x := ExpressionConfig{
+		Name:       "test_read_metrics_expressions",
+		Expression: "m1 * 2",
+		Metrics:    map[string]ExpressionMetric{"m1": {Namespace: "AWS/SQS", Name: "NumberOfMessagesSent", Statistic: "Sum"}},
+	}
+	assert.NoError(t, x.Validate())
+	defer prometheus.Unregister(x.collector)
+
+	clients := []Client{{Region: "us-east-1", CloudWatch: stubCloudWatch{}}}
+	metricstats := unrollMetrics(expressionsToRead([]ExpressionConfig{x}, clients))
+
+	gauge := &mockGauge{}
+	ms := metricstats["i0"]
+	ms.gauge = gauge
+	metricstats["i0"] = ms
+
+	assert.NoError(t, ReadMetrics(clients, time.Now(), metricstats, 1))
+	if assert.NotNil(t, gauge.value) {
+		assert.Equal(t, 1.0, *gauge.value)
+	}
+}
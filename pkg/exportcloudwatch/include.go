@@ -1,35 +1,58 @@
 package exportcloudwatch
 
 import (
-	"sort"
-
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 )
 
 func includeMetric(e ExportConfig, m *cloudwatch.Metric) bool {
-	if len(m.Dimensions) != len(e.Dimensions) {
+	if e.NameMatch != nil && !e.NameMatch.MatchString(aws.StringValue(m.MetricName)) {
+		return false
+	}
+	if e.NameNoMatch != nil && e.NameNoMatch.MatchString(aws.StringValue(m.MetricName)) {
 		return false
 	}
 
-	got := make([]string, 0, len(e.Dimensions))
-
+	values := make(map[string]string, len(m.Dimensions))
 	for _, d := range m.Dimensions {
-		if re := e.DimensionsNoMatch[*d.Name]; re != nil && re.MatchString(*d.Value) {
+		if !e.ExtraDimensions && !contains(e.Dimensions, *d.Name) && !contains(e.OptionalDimensions, *d.Name) {
+			return false
+		}
+		values[*d.Name] = *d.Value
+	}
+
+	for _, d := range e.Dimensions {
+		if _, ok := values[d]; !ok {
+			return false
+		}
+	}
+
+	// a missing optional dimension is matched as empty
+	for k, re := range e.DimensionsNoMatch {
+		if re.MatchString(values[k]) {
 			return false
 		}
-		if re := e.DimensionsMatch[*d.Name]; re != nil && !re.MatchString(*d.Value) {
+	}
+	for k, re := range e.DimensionsMatch {
+		if !re.MatchString(values[k]) {
 			return false
 		}
-		got = append(got, *d.Name)
 	}
 
-	expect := e.Dimensions
+	return true
+}
 
-	// expect is already sorted when validating the config
-	sort.Strings(got)
+// includeTags returns true if the tags of a resource pass the TagsMatch and
+// TagsNoMatch of e
+func includeTags(e ExportConfig, tags map[string]string) bool {
+	for k, re := range e.TagsMatch {
+		if !re.MatchString(tags[k]) {
+			return false
+		}
+	}
 
-	for i, e := range expect {
-		if e != got[i] {
+	for k, re := range e.TagsNoMatch {
+		if re.MatchString(tags[k]) {
 			return false
 		}
 	}
@@ -85,6 +85,90 @@ func TestIncludeMetric(t *testing.T) {
 				}},
 			},
 		},
+		{
+			name:   "OptionalDimensions (present)",
+			result: true,
+			ExportConfig: ExportConfig{
+				Dimensions:         []string{"LoadBalancer"},
+				OptionalDimensions: []string{"AvailabilityZone"},
+				Statistics:         []string{"Sum"},
+			},
+			cloudwatchMetric: &cloudwatch.Metric{
+				Dimensions: []*cloudwatch.Dimension{{
+					Name:  aws.String("AvailabilityZone"),
+					Value: aws.String("us-east-1a"),
+				}, {
+					Name:  aws.String("LoadBalancer"),
+					Value: aws.String("app/web"),
+				}},
+			},
+		},
+		{
+			name:   "OptionalDimensions (missing)",
+			result: true,
+			ExportConfig: ExportConfig{
+				Dimensions:         []string{"LoadBalancer"},
+				OptionalDimensions: []string{"AvailabilityZone"},
+				Statistics:         []string{"Sum"},
+			},
+			cloudwatchMetric: &cloudwatch.Metric{
+				Dimensions: []*cloudwatch.Dimension{{
+					Name:  aws.String("LoadBalancer"),
+					Value: aws.String("app/web"),
+				}},
+			},
+		},
+		{
+			name:   "OptionalDimensions (missing, DimensionsMatch)",
+			result: false,
+			ExportConfig: ExportConfig{
+				Dimensions:         []string{"LoadBalancer"},
+				OptionalDimensions: []string{"AvailabilityZone"},
+				Statistics:         []string{"Sum"},
+				DimensionsMatch: map[string]*regexp.Regexp{
+					"AvailabilityZone": regexp.MustCompile("^us-"),
+				},
+			},
+			cloudwatchMetric: &cloudwatch.Metric{
+				Dimensions: []*cloudwatch.Dimension{{
+					Name:  aws.String("LoadBalancer"),
+					Value: aws.String("app/web"),
+				}},
+			},
+		},
+		{
+			name:   "ExtraDimensions",
+			result: true,
+			ExportConfig: ExportConfig{
+				Dimensions:      []string{"QueueName"},
+				ExtraDimensions: true,
+				Statistics:      []string{"Sum"},
+			},
+			cloudwatchMetric: &cloudwatch.Metric{
+				Dimensions: []*cloudwatch.Dimension{{
+					Name:  aws.String("Bonk"),
+					Value: aws.String("bar"),
+				}, {
+					Name:  aws.String("QueueName"),
+					Value: aws.String("bar"),
+				}},
+			},
+		},
+		{
+			name:   "ExtraDimensions (missing QueueName)",
+			result: false,
+			ExportConfig: ExportConfig{
+				Dimensions:      []string{"QueueName"},
+				ExtraDimensions: true,
+				Statistics:      []string{"Sum"},
+			},
+			cloudwatchMetric: &cloudwatch.Metric{
+				Dimensions: []*cloudwatch.Dimension{{
+					Name:  aws.String("Bonk"),
+					Value: aws.String("bar"),
+				}},
+			},
+		},
 	}
 
 	for _, test := range tests {
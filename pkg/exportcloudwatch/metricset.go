@@ -0,0 +1,74 @@
+package exportcloudwatch
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricSet holds the result of MetricsToRead so that it can be replaced while
+// other goroutines are reading metrics with it.  The zero value is an empty
+// set.
+type MetricSet struct {
+	v atomic.Value
+
+	// mu serializes Update
+	mu sync.Mutex
+}
+
+// Load returns the current metrics; the returned map must not be modified.
+func (s *MetricSet) Load() map[string]MetricStat {
+	m, _ := s.v.Load().(map[string]MetricStat)
+	return m
+}
+
+// Store replaces the current metrics with m, which must not be modified
+// afterwards.
+func (s *MetricSet) Store(m map[string]MetricStat) {
+	s.v.Store(m)
+}
+
+// seriesID identifies the exported series of a MetricStat
+type seriesID struct {
+	collector   gaugeVec
+	labelValues string
+}
+
+func seriesKey(ms MetricStat) seriesID {
+	return seriesID{ms.collector, strings.Join(ms.labelValues, "\xff")}
+}
+
+// Update replaces the current metrics with m, the result of a newer call to
+// MetricsToRead.  Metrics in the current set that are missing from m are kept
+// until they have not been listed for their ExportConfig's GracePeriod, after
+// which their series are deleted.
+func (s *MetricSet) Update(m map[string]MetricStat, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listed := make(map[seriesID]struct{}, len(m))
+	ms := make([]MetricStat, 0, len(m))
+	for _, v := range m {
+		v.lastListed = now
+		listed[seriesKey(v)] = struct{}{}
+		ms = append(ms, v)
+	}
+
+	for _, v := range s.Load() {
+		if _, ok := listed[seriesKey(v)]; ok {
+			continue
+		}
+
+		if now.Sub(v.lastListed) < v.gracePeriod {
+			ms = append(ms, v)
+			continue
+		}
+
+		if v.collector != nil {
+			v.collector.DeleteLabelValues(v.labelValues...)
+		}
+	}
+
+	s.Store(unrollMetrics(ms))
+}
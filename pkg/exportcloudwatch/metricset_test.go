@@ -0,0 +1,117 @@
+package exportcloudwatch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricSetZero(t *testing.T) {
+	var s MetricSet
+
+	assert.Empty(t, s.Load())
+	assert.NoError(t, ReadMetrics(nil, time.Now(), s.Load(), 1))
+}
+
+// TestMetricSetConcurrentRefresh is meant to be run with -race; it refreshes
+// the set while metrics are being read and scraped.
+func TestMetricSetConcurrentRefresh(t *testing.T) {
+	metrics := make([]*cloudwatch.Metric, 0, 3)
+	for _, q := range []string{"a", "b", "c"} {
+		metrics = append(metrics, &cloudwatch.Metric{
+			Namespace:  aws.String("AWS/SQS"),
+			MetricName: aws.String("TestMetricSetConcurrentRefresh"),
+			Dimensions: []*cloudwatch.Dimension{{
+				Name:  aws.String("QueueName"),
+				Value: aws.String(q),
+			}},
+		})
+	}
+	clients := []Client{{Region: "us-east-1", CloudWatch: stubCloudWatch{metrics: metrics}}}
+
+	e := ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "TestMetricSetConcurrentRefresh",
+		Dimensions: []string{"QueueName"},
+		Statistics: []string{"Sum", "Maximum"},
+	}
+	assert.NoError(t, e.Validate())
+
+	var s MetricSet
+	ms, err := MetricsToRead([]ExportConfig{e}, nil, clients)
+	assert.NoError(t, err)
+	s.Store(ms)
+
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				f()
+			}
+		}()
+	}
+
+	run(func() {
+		ms, err := MetricsToRead([]ExportConfig{e}, nil, clients)
+		assert.NoError(t, err)
+		s.Store(ms)
+	})
+	run(func() {
+		assert.NoError(t, ReadMetrics(clients, time.Now(), s.Load(), 4))
+	})
+	run(func() {
+		_, err := prometheus.DefaultGatherer.Gather()
+		assert.NoError(t, err)
+	})
+	wg.Wait()
+
+	assert.Len(t, s.Load(), 6)
+}
+
+func TestMetricSetUpdate(t *testing.T) {
+	vec := promGaugeVec{prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_metric_set_update"}, []string{"queue_name"})}
+	stat := func(queue string, gracePeriod time.Duration) MetricStat {
+		return MetricStat{
+			statistic:   "Sum",
+			gauge:       vec.with(queue),
+			collector:   vec,
+			labelValues: []string{queue},
+			gracePeriod: gracePeriod,
+		}
+	}
+	queues := func(s *MetricSet) []string {
+		var ret []string
+		for _, ms := range s.Load() {
+			ret = append(ret, ms.labelValues[0])
+		}
+		return ret
+	}
+
+	now := time.Now()
+	var s MetricSet
+	s.Update(unrollMetrics([]MetricStat{
+		stat("deleted", 0),
+		stat("graceful", 10*time.Minute),
+		stat("kept", 0),
+	}), now)
+	assert.ElementsMatch(t, []string{"deleted", "graceful", "kept"}, queues(&s))
+	assert.Equal(t, 3, testutil.CollectAndCount(vec))
+
+	now = now.Add(5 * time.Minute)
+	s.Update(unrollMetrics([]MetricStat{stat("kept", 0)}), now)
+	assert.ElementsMatch(t, []string{"graceful", "kept"}, queues(&s), "graceful is still within its grace period")
+	assert.Equal(t, 2, testutil.CollectAndCount(vec))
+
+	now = now.Add(5 * time.Minute)
+	s.Update(unrollMetrics([]MetricStat{stat("kept", 0)}), now)
+	assert.ElementsMatch(t, []string{"kept"}, queues(&s), "graceful is past its grace period")
+	assert.Equal(t, 1, testutil.CollectAndCount(vec))
+}
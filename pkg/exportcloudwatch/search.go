@@ -0,0 +1,153 @@
+package exportcloudwatch
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// searchLabelSeparator separates the dimension values in the labels of SEARCH
+// results; a label whose values contain it can't be split, and fails the
+// search
+const searchLabelSeparator = "␟"
+
+var searchTruncated = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "monitoring_cloudwatch_search_truncated_total",
+	Help: "Count of SEARCH expressions that returned only some of the series they matched, by namespace",
+}, []string{"namespace"})
+
+func init() {
+	prometheus.MustRegister(searchTruncated)
+}
+
+// searchEscape escapes the single quotes of s, for use in the single quoted
+// SEARCH expression
+func searchEscape(s string) string {
+	return strings.ReplaceAll(s, `'`, `\'`)
+}
+
+// searchQuote quotes s for use as a term of a SEARCH expression
+func searchQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+
+	return `"` + s + `"`
+}
+
+// searchQuery returns the query that finds the metrics of e with data, labeled
+// with their name and then their dimension values in the order of
+// e.Dimensions
+func searchQuery(e ExportConfig, period time.Duration) *cloudwatch.MetricDataQuery {
+	schema := make([]string, 0, len(e.Dimensions)+1)
+	schema = append(schema, searchQuote(e.Namespace))
+	label := make([]string, 0, len(e.Dimensions)+1)
+	label = append(label, "${PROP('MetricName')}")
+	for _, d := range e.Dimensions {
+		schema = append(schema, searchQuote(d))
+		label = append(label, "${PROP('Dim."+searchEscape(d)+"')}")
+	}
+
+	search := "{" + strings.Join(schema, ",") + "}"
+	if !e.matchesNames() {
+		search += " MetricName=" + searchQuote(e.Name)
+	}
+
+	expression := "SEARCH('" + searchEscape(search) + "', '" + e.Statistics[0] + "', " +
+		strconv.Itoa(int(period/time.Second)) + ")"
+
+	return &cloudwatch.MetricDataQuery{
+		Id:         aws.String("search"),
+		Expression: aws.String(expression),
+		Label:      aws.String(strings.Join(label, searchLabelSeparator)),
+		ReturnData: aws.Bool(true),
+	}
+}
+
+// searchMetrics returns the metrics of exportConfig that have data in its
+// window as of now, as found by a SEARCH expression read with c
+func searchMetrics(exportConfig ExportConfig, c Client, now time.Time) ([]*cloudwatch.Metric, error) {
+	w := exportConfig.window().withDefaults()
+	start, end := w.bounds(now)
+
+	gmdi := &cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(start),
+		EndTime:           aws.Time(end),
+		MetricDataQueries: []*cloudwatch.MetricDataQuery{searchQuery(exportConfig, w.period)},
+	}
+
+	var metrics []*cloudwatch.Metric
+	var truncated bool
+	seen := make(map[string]bool)
+	for {
+		gmdo, err := c.CloudWatch.GetMetricData(gmdi)
+		if err != nil {
+			return nil, errors.Wrap(err, "cloudwatch.GetMetricData")
+		}
+		for _, m := range gmdo.Messages {
+			log.Printf("namespace=%s: cloudwatch.GetMetricData SEARCH: %s: %s", exportConfig.Namespace, aws.StringValue(m.Code), aws.StringValue(m.Value))
+			cloudwatchGetMetricDataMessagesCounter.With(prometheus.Labels{"code": aws.StringValue(m.Code)}).Inc()
+		}
+
+		for _, r := range gmdo.MetricDataResults {
+			// a search that matches too many series returns only some of
+			// them, which is reported on the results rather than the
+			// response
+			for _, m := range r.Messages {
+				log.Printf("namespace=%s: cloudwatch.GetMetricData SEARCH: %s: %s", exportConfig.Namespace, aws.StringValue(m.Code), aws.StringValue(m.Value))
+				cloudwatchGetMetricDataMessagesCounter.With(prometheus.Labels{"code": aws.StringValue(m.Code)}).Inc()
+			}
+			if aws.StringValue(r.StatusCode) == cloudwatch.StatusCodePartialData && gmdo.NextToken == nil {
+				truncated = true
+			}
+
+			// the datapoints of a series may be split across pages
+			label := aws.StringValue(r.Label)
+			if len(r.Values) == 0 || seen[label] {
+				continue
+			}
+
+			values := strings.Split(label, searchLabelSeparator)
+			if len(values) != len(exportConfig.Dimensions)+1 {
+				return nil, errors.New("unexpected SEARCH label " + label)
+			}
+
+			metric := &cloudwatch.Metric{
+				Namespace:  aws.String(exportConfig.Namespace),
+				MetricName: aws.String(values[0]),
+				Dimensions: make([]*cloudwatch.Dimension, len(values)-1),
+			}
+			values = values[1:]
+			for i, v := range values {
+				metric.Dimensions[i] = &cloudwatch.Dimension{
+					Name:  aws.String(exportConfig.Dimensions[i]),
+					Value: aws.String(v),
+				}
+			}
+			if !includeMetric(exportConfig, metric) {
+				continue
+			}
+
+			seen[label] = true
+			metrics = append(metrics, metric)
+		}
+
+		if gmdo.NextToken != nil {
+			gmdi.NextToken = gmdo.NextToken
+		} else {
+			break
+		}
+	}
+
+	if truncated {
+		log.Printf("account=%s region=%s namespace=%s: SEARCH returned only some of the series it matched; %d metrics were found", c.AccountID, c.Region, exportConfig.Namespace, len(metrics))
+		searchTruncated.WithLabelValues(exportConfig.Namespace).Inc()
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,112 @@
+package exportcloudwatch
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchQuery(t *testing.T) {
+	e := ExportConfig{
+		Namespace:  "AWS/S3",
+		Name:       "BucketSizeBytes",
+		Dimensions: []string{"BucketName", "StorageType"},
+		Statistics: []string{"Average", "Maximum"},
+	}
+
+	q := searchQuery(e, 24*time.Hour)
+	assert.Equal(t,
+		`SEARCH('{"AWS/S3","BucketName","StorageType"} MetricName="BucketSizeBytes"', 'Average', 86400)`,
+		*q.Expression)
+	assert.Equal(t, "${PROP('MetricName')}␟${PROP('Dim.BucketName')}␟${PROP('Dim.StorageType')}", *q.Label)
+
+	e.Name = `Say "hi"`
+	assert.Contains(t, *searchQuery(e, time.Minute).Expression, `MetricName="Say \"hi\""`)
+
+	e.Name = "Bob's"
+	e.Dimensions = []string{"Bob's"}
+	q = searchQuery(e, time.Minute)
+	assert.Equal(t, `SEARCH('{"AWS/S3","Bob\'s"} MetricName="Bob\'s"', 'Average', 60)`, *q.Expression)
+	assert.Equal(t, `${PROP('MetricName')}␟${PROP('Dim.Bob\'s')}`, *q.Label)
+	e.Dimensions = []string{"BucketName", "StorageType"}
+
+	e.Name = ""
+	e.NameMatch = regexp.MustCompile("^Bucket")
+	assert.Equal(t,
+		`SEARCH('{"AWS/S3","BucketName","StorageType"}', 'Average', 60)`,
+		*searchQuery(e, time.Minute).Expression)
+}
+
+func TestSearchMetrics(t *testing.T) {
+	one := 1.0
+	pcw := &pagedCloudWatch{pages: []*cloudwatch.GetMetricDataOutput{{
+		MetricDataResults: []*cloudwatch.MetricDataResult{
+			{Id: aws.String("search"), Label: aws.String("BucketSizeBytes␟a␟STANDARD"), Values: []*float64{&one}},
+			{Id: aws.String("search"), Label: aws.String("BucketSizeBytes␟dormant␟STANDARD")},
+			{Id: aws.String("search"), Label: aws.String("BucketSizeBytes␟excluded␟STANDARD"), Values: []*float64{&one}},
+		},
+	}, {
+		MetricDataResults: []*cloudwatch.MetricDataResult{
+			{Id: aws.String("search"), Label: aws.String("BucketSizeBytes␟a␟STANDARD"), Values: []*float64{&one}},
+			{Id: aws.String("search"), Label: aws.String("BucketSizeBytes␟b␟GLACIER"), Values: []*float64{&one}},
+		},
+	}}}
+
+	e := ExportConfig{
+		Namespace:         "AWS/S3",
+		Name:              "BucketSizeBytes",
+		Dimensions:        []string{"StorageType", "BucketName"},
+		Statistics:        []string{"Average"},
+		DimensionsNoMatch: map[string]*regexp.Regexp{"BucketName": regexp.MustCompile("^excluded$")},
+		Search:            true,
+	}
+	assert.NoError(t, e.Validate())
+	defer prometheus.Unregister(e.collectors[0])
+
+	clients := []Client{{Region: "us-east-1", CloudWatch: pcw}}
+	metrics, err := metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+
+	var got [][]string
+	for _, m := range metrics {
+		got = append(got, m.labelValues)
+		assert.Equal(t, "AWS/S3", *m.cloudwatchMetric.Namespace)
+	}
+	assert.Equal(t, [][]string{{"", "us-east-1", "a", "STANDARD"}, {"", "us-east-1", "b", "GLACIER"}}, got)
+
+	if assert.Len(t, pcw.inputs, 2) {
+		start, end := e.window().withDefaults().bounds(time.Now())
+		assert.WithinDuration(t, start, *pcw.inputs[0].StartTime, time.Minute)
+		assert.WithinDuration(t, end, *pcw.inputs[0].EndTime, time.Minute)
+	}
+
+	// a search of too many series is counted
+	pcw = &pagedCloudWatch{pages: []*cloudwatch.GetMetricDataOutput{{
+		MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         aws.String("search"),
+			Label:      aws.String("BucketSizeBytes␟a␟STANDARD"),
+			Values:     []*float64{&one},
+			StatusCode: aws.String(cloudwatch.StatusCodePartialData),
+			Messages:   []*cloudwatch.MessageData{{Code: aws.String("MaxQueryResultsExceeded"), Value: aws.String("too many series")}},
+		}},
+	}}}
+	truncated := testutil.ToFloat64(searchTruncated.WithLabelValues("AWS/S3"))
+	found, err := searchMetrics(e, Client{CloudWatch: pcw}, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, truncated+1, testutil.ToFloat64(searchTruncated.WithLabelValues("AWS/S3")))
+
+	pcw = &pagedCloudWatch{pages: []*cloudwatch.GetMetricDataOutput{{
+		MetricDataResults: []*cloudwatch.MetricDataResult{
+			{Id: aws.String("search"), Label: aws.String("BucketSizeBytes␟a"), Values: []*float64{&one}},
+		},
+	}}}
+	_, err = searchMetrics(e, Client{CloudWatch: pcw}, time.Now())
+	assert.EqualError(t, err, "unexpected SEARCH label BucketSizeBytes␟a")
+}
@@ -0,0 +1,111 @@
+package exportcloudwatch
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// standardStatistics are the statistics CloudWatch always has
+var standardStatistics = map[string]bool{
+	"SampleCount": true,
+	"Average":     true,
+	"Sum":         true,
+	"Minimum":     true,
+	"Maximum":     true,
+}
+
+var (
+	// percentileRE matches percentiles like p99 and p99.9
+	percentileRE = regexp.MustCompile(`^p([0-9]{1,2}(\.[0-9]{1,10})?|100)$`)
+
+	// shorthandRE matches the shorthands of the trimmed and winsorized
+	// statistics, like tm99 for TM(0%:99%)
+	shorthandRE = regexp.MustCompile(`^(tm|wm|tc|ts)([0-9]{1,2}(\.[0-9]{1,10})?|100)$`)
+
+	// rangeRE matches statistics over a range of percentiles or values, like
+	// TM(10%:90%) or PR(:300)
+	rangeRE = regexp.MustCompile(`^(TM|WM|TC|TS|PR)\(([0-9.]*%?):([0-9.]*%?)\)$`)
+)
+
+// validStatistic returns true if s is a standard or extended CloudWatch
+// statistic
+func validStatistic(s string) bool {
+	if standardStatistics[s] || s == "IQM" || percentileRE.MatchString(s) || shorthandRE.MatchString(s) {
+		return true
+	}
+
+	m := rangeRE.FindStringSubmatch(s)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return false
+	}
+
+	// PR is over values only, and the bounds of the others are either both
+	// percentiles or both values
+	lower, upper := m[2], m[3]
+	percent := strings.HasSuffix(lower, "%") || strings.HasSuffix(upper, "%")
+	if percent && m[1] == "PR" {
+		return false
+	}
+
+	var bounds []float64
+	for _, b := range []string{lower, upper} {
+		if b == "" {
+			continue
+		}
+		if percent != strings.HasSuffix(b, "%") {
+			return false
+		}
+
+		f, err := strconv.ParseFloat(strings.TrimSuffix(b, "%"), 64)
+		if err != nil || (percent && f > 100) {
+			return false
+		}
+		bounds = append(bounds, f)
+	}
+
+	return len(bounds) != 2 || bounds[0] <= bounds[1]
+}
+
+// isPercentile returns true if s is a percentile statistic like p99
+func isPercentile(s string) bool {
+	return percentileRE.MatchString(s)
+}
+
+// statisticSuffix maps an extended statistic to the suffix of a prometheus
+// name, like p99_9 for p99.9 and tm_10pct_to_90pct for TM(10%:90%)
+func statisticSuffix(s string) string {
+	s = strings.NewReplacer(
+		".", "_",
+		"%", "pct",
+		"(", "_",
+		")", "",
+		":", "_to_",
+	).Replace(strings.ToLower(s))
+
+	return strings.Trim(strings.ReplaceAll(s, "__", "_"), "_")
+}
+
+// quantile returns the quantile of the percentile statistic s as the value of
+// a quantile label, like 0.999 for p99.9
+func quantile(s string) string {
+	percent := strings.TrimPrefix(s, "p")
+	whole, frac := percent, ""
+	if i := strings.IndexByte(percent, '.'); i >= 0 {
+		whole, frac = percent[:i], percent[i+1:]
+	}
+
+	if whole == "100" {
+		return "1"
+	}
+	if len(whole) == 1 {
+		whole = "0" + whole
+	}
+
+	q := strings.TrimRight("0."+whole+frac, "0")
+	if q == "0." {
+		return "0"
+	}
+
+	return q
+}
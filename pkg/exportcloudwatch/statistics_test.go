@@ -0,0 +1,170 @@
+package exportcloudwatch
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidStatistic(t *testing.T) {
+	valid := []string{
+		"Sum", "SampleCount", "IQM",
+		"p0", "p50", "p99.9", "p99.99", "p100",
+		"tm99", "wm90", "tc99.5", "ts50",
+		"TM(10%:90%)", "TM(:90%)", "WM(5%:)", "TC(0.005:0.030)", "TS(80%:)", "PR(:300)", "PR(100:2000)",
+	}
+	for _, s := range valid {
+		assert.True(t, validStatistic(s), s)
+	}
+
+	invalid := []string{
+		"", "sum", "Median", "p", "p101", "p99.", "P99", "tm", "tm101",
+		"TM()", "TM(:)", "TM(90%:10%)", "TM(10%:90)", "TM(10%:200%)", "PR(10%:90%)", "XX(1:2)", "TM(a:b)",
+	}
+	for _, s := range invalid {
+		assert.False(t, validStatistic(s), s)
+	}
+}
+
+func TestStatisticSuffix(t *testing.T) {
+	for in, out := range map[string]string{
+		"p99":          "p99",
+		"p99.9":        "p99_9",
+		"tm99":         "tm99",
+		"TM(10%:90%)":  "tm_10pct_to_90pct",
+		"TM(10:90)":    "tm_10_to_90",
+		"TM(:90%)":     "tm_to_90pct",
+		"WM(10%:)":     "wm_10pct_to",
+		"PR(:300)":     "pr_to_300",
+		"TC(0.5:1.25)": "tc_0_5_to_1_25",
+		"IQM":          "iqm",
+	} {
+		assert.Equal(t, out, statisticSuffix(in), in)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	for in, out := range map[string]string{
+		"p0":     "0",
+		"p5":     "0.05",
+		"p50":    "0.5",
+		"p99":    "0.99",
+		"p99.9":  "0.999",
+		"p99.99": "0.9999",
+		"p100":   "1",
+	} {
+		assert.Equal(t, out, quantile(in), in)
+	}
+}
+
+func TestExtendedStatisticNames(t *testing.T) {
+	e := ExportConfig{
+		Namespace:  "AWS/ApplicationELB",
+		Name:       "TargetResponseTime",
+		Dimensions: []string{"LoadBalancer"},
+		Statistics: []string{"Average", "p99.9", "TM(10%:90%)"},
+	}
+	assert.NoError(t, e.Validate())
+	defer func() {
+		for _, c := range e.collectors {
+			prometheus.Unregister(c)
+		}
+	}()
+
+	assert.Equal(t, "aws_applicationelb_target_response_time_average", e.String(0))
+	assert.Equal(t, "aws_applicationelb_target_response_time_p99_9", e.String(1))
+	assert.Equal(t, "aws_applicationelb_target_response_time_tm_10pct_to_90pct", e.String(2))
+
+	e.Statistics = []string{"Sum", "p99.9?"}
+	assert.EqualError(t, e.Validate(), "Invalid statistic p99.9?")
+}
+
+func TestLowercaseNames(t *testing.T) {
+	percentile := ExportConfig{
+		Namespace:  "Custom/Percentile",
+		Name:       "latency",
+		Statistics: []string{"p99"},
+	}
+	assert.NoError(t, percentile.Validate())
+	assert.Equal(t, "custom_percentile_latency_p99", percentile.String(0))
+
+	quantiles := ExportConfig{
+		Namespace:     "Custom/Quantile",
+		Name:          "latency",
+		Statistics:    []string{"p99"},
+		QuantileLabel: true,
+	}
+	assert.NoError(t, quantiles.Validate())
+	assert.Equal(t, "custom_quantile_latency", quantiles.String(0))
+
+	summary := ExportConfig{
+		Namespace:  "Custom/Summary",
+		Name:       "latency",
+		Statistics: []string{"SampleCount", "Sum", "p99"},
+		Summary:    true,
+	}
+	assert.NoError(t, summary.Validate())
+	assert.Equal(t, "custom_summary_latency", summary.String(2))
+
+	// names that are matched are converted when they're found
+	matched := ExportConfig{
+		Namespace:     "Custom/Matched",
+		NameMatch:     regexp.MustCompile("^lat"),
+		Statistics:    []string{"p99"},
+		QuantileLabel: true,
+	}
+	assert.NoError(t, matched.Validate())
+	metric := &cloudwatch.Metric{Namespace: aws.String("Custom/Matched"), MetricName: aws.String("latency")}
+	clients := []Client{{Region: "us-east-1", CloudWatch: stubCloudWatch{metrics: []*cloudwatch.Metric{metric}}}}
+	ms, err := metricsToRead([]ExportConfig{matched}, clients)
+	assert.NoError(t, err)
+	assert.Len(t, ms, 1)
+}
+
+func TestQuantileLabel(t *testing.T) {
+	e := ExportConfig{
+		Namespace:     "AWS/ApplicationELB",
+		Name:          "TestQuantileLabel",
+		Dimensions:    []string{"LoadBalancer"},
+		Statistics:    []string{"p50", "Maximum", "p99"},
+		QuantileLabel: true,
+	}
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, e.collectors[0], e.collectors[2])
+	assert.Equal(t, "aws_applicationelb_test_quantile_label", e.String(0))
+	assert.Equal(t, "aws_applicationelb_test_quantile_label_maximum", e.String(1))
+
+	metric := &cloudwatch.Metric{
+		Namespace:  aws.String("AWS/ApplicationELB"),
+		MetricName: aws.String("TestQuantileLabel"),
+		Dimensions: []*cloudwatch.Dimension{{Name: aws.String("LoadBalancer"), Value: aws.String("app/web")}},
+	}
+	clients := []Client{{Region: "us-east-1", CloudWatch: stubCloudWatch{metrics: []*cloudwatch.Metric{metric}}}}
+	ms, err := metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+
+	var got [][]string
+	for _, m := range ms {
+		got = append(got, m.labelValues)
+	}
+	assert.Equal(t, [][]string{
+		{"", "us-east-1", "app/web", "0.5"},
+		{"", "us-east-1", "app/web"},
+		{"", "us-east-1", "app/web", "0.99"},
+	}, got)
+	assert.Equal(t, 2, testutil.CollectAndCount(e.collectors[0]))
+
+	collides := ExportConfig{
+		Namespace:     "AWS/Custom",
+		Name:          "Latency",
+		Dimensions:    []string{"Quantile"},
+		Statistics:    []string{"p99"},
+		QuantileLabel: true,
+	}
+	assert.EqualError(t, collides.Validate(), "Dimension Quantile collides with the quantile label")
+}
@@ -5,11 +5,22 @@ import (
 	"strings"
 )
 
+// metricNameRE and labelNameRE are what prometheus allows as names
+var (
+	metricNameRE = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+	labelNameRE  = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+)
+
 var re = regexp.MustCompile("[A-Z][a-z0-9_]+")
 
 func cloudWatchToPrometheusNameV0(in string) string {
 	found := re.FindAllString(in, -1)
 
+	// names without capitalized words, like latency, are used as they are
+	if len(found) == 0 {
+		return strings.ToLower(in)
+	}
+
 	ret := strings.ToLower(found[0])
 	for _, s := range found[1:] {
 		ret += "_" + strings.ToLower(s)
@@ -14,6 +14,8 @@ type stringTest struct {
 var stringTestsV0 []stringTest = []stringTest{
 	{input: "AllErrors", expectedOutput: "all_errors"},
 	{input: "DescribeDeliveryStream.Requests", expectedOutput: "describe_delivery_stream_requests"},
+	{input: "latency", expectedOutput: "latency"},
+	{input: "", expectedOutput: ""},
 }
 
 var stringTestsV1 []stringTest = []stringTest{
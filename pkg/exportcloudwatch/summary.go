@@ -0,0 +1,217 @@
+package exportcloudwatch
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// summaryVec is a collector of summaries partitioned by label values, whose
+// count, sum and quantiles are each read from a statistic.  The count and sum
+// accumulate the datapoints of SampleCount and Sum, like a counterVec, and the
+// quantiles are the latest datapoints.  A series is only exported once its
+// count and sum have been read.
+type summaryVec struct {
+	desc *prometheus.Desc
+
+	// timestamps exports each summary with the latest time any of its parts
+	// was set with
+	timestamps bool
+
+	mu       sync.Mutex
+	children map[string]*summaryChild
+}
+
+func newSummaryVec(opts prometheus.GaugeOpts, labelNames []string, timestamps bool) *summaryVec {
+	return &summaryVec{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help,
+			labelNames,
+			opts.ConstLabels,
+		),
+		timestamps: timestamps,
+		children:   make(map[string]*summaryChild),
+	}
+}
+
+func (v *summaryVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+func (v *summaryVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, c := range v.children {
+		if !c.hasCount || !c.hasSum {
+			continue
+		}
+
+		quantiles := make(map[float64]float64, len(c.quantiles))
+		for q, value := range c.quantiles {
+			quantiles[q] = value
+		}
+
+		m, err := prometheus.NewConstSummary(v.desc, uint64(c.count), c.sum, quantiles, c.labelValues...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(v.desc, err)
+		} else if v.timestamps && !c.timestamp.IsZero() {
+			m = prometheus.NewMetricWithTimestamp(c.timestamp, m)
+		}
+		ch <- m
+	}
+}
+
+func (v *summaryVec) DeleteLabelValues(labelValues ...string) bool {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, ok := v.children[key]
+	delete(v.children, key)
+
+	return ok
+}
+
+// part returns the gaugeVec of the part of v that statistic is read into
+func (v *summaryVec) part(statistic string) summaryPart {
+	switch statistic {
+	case "SampleCount":
+		return summaryPart{summaryVec: v, kind: summaryCount}
+	case "Sum":
+		return summaryPart{summaryVec: v, kind: summarySum}
+	default:
+		q, _ := strconv.ParseFloat(quantile(statistic), 64)
+		return summaryPart{summaryVec: v, kind: summaryQuantile, quantile: q}
+	}
+}
+
+// summaryChild is a single series of a summaryVec
+type summaryChild struct {
+	labelValues []string
+
+	count, sum           float64
+	hasCount, hasSum     bool
+	countAdded, sumAdded addedTimes
+	quantiles            map[float64]float64
+	timestamp            time.Time
+}
+
+// the parts of a summary
+const (
+	summaryCount = iota
+	summarySum
+	summaryQuantile
+)
+
+// summaryPart is the gaugeVec of the count, sum or a quantile of a summaryVec
+type summaryPart struct {
+	*summaryVec
+
+	kind     int
+	quantile float64
+}
+
+func (p summaryPart) with(labelValues ...string) gauge {
+	g := summaryGauge{part: p, labelValues: labelValues}
+	if p.kind == summaryQuantile {
+		return g
+	}
+
+	return summaryAccumulator{g}
+}
+
+// summaryGauge sets a part of a single series of a summaryVec
+type summaryGauge struct {
+	part        summaryPart
+	labelValues []string
+}
+
+func (g summaryGauge) Set(f float64) {
+	g.SetWithTimestamp(f, time.Time{})
+}
+
+func (g summaryGauge) SetWithTimestamp(f float64, t time.Time) {
+	v := g.part.summaryVec
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c := v.child(g.labelValues)
+	c.quantiles[g.part.quantile] = f
+	if t.After(c.timestamp) {
+		c.timestamp = t
+	}
+}
+
+// child returns the series of labelValues, creating it if needed; v.mu must
+// be held
+func (v *summaryVec) child(labelValues []string) *summaryChild {
+	key := strings.Join(labelValues, "\xff")
+	c, ok := v.children[key]
+	if !ok {
+		c = &summaryChild{
+			labelValues: labelValues,
+			countAdded:  make(addedTimes),
+			sumAdded:    make(addedTimes),
+			quantiles:   make(map[float64]float64),
+		}
+		v.children[key] = c
+	}
+
+	return c
+}
+
+// summaryAccumulator adds the datapoints of SampleCount or Sum to the count or
+// sum of a single series of a summaryVec
+type summaryAccumulator struct {
+	summaryGauge
+}
+
+// Set does nothing, since the count and sum only change by the datapoints
+// added to them; it is what a StatDefault would set.
+func (a summaryAccumulator) Set(float64) {}
+
+func (a summaryAccumulator) add(value float64, t time.Time) {
+	v := a.part.summaryVec
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c := v.child(a.labelValues)
+	if a.part.kind == summaryCount {
+		if !c.countAdded.add(t) {
+			return
+		}
+		c.count += value
+		c.hasCount = true
+	} else {
+		if !c.sumAdded.add(t) {
+			return
+		}
+		c.sum += value
+		c.hasSum = true
+	}
+	if t.After(c.timestamp) {
+		c.timestamp = t
+	}
+}
+
+func (a summaryAccumulator) forget(start time.Time) {
+	v := a.part.summaryVec
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.children[strings.Join(a.labelValues, "\xff")]
+	if !ok {
+		return
+	}
+	c.countAdded.forget(start)
+	c.sumAdded.forget(start)
+}
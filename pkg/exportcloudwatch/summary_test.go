@@ -0,0 +1,111 @@
+package exportcloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryVec(t *testing.T) {
+	v := newSummaryVec(prometheus.GaugeOpts{Name: "test_summary_vec"}, []string{"queue_name"}, true)
+	ts := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	count := v.part("SampleCount").with("a").(accumulator)
+	sum := v.part("Sum").with("a").(accumulator)
+	count.add(10, ts.Add(-time.Minute))
+	count.add(4, ts)
+	count.add(4, ts)
+	count.Set(0)
+	sum.add(1.5, ts.Add(-time.Minute))
+	sum.add(2.5, ts)
+	v.part("p99").with("a").Set(0.75)
+	v.part("p99.9").with("a").Set(0.9)
+	_, ok := v.part("p99").with("a").(accumulator)
+	assert.False(t, ok, "quantiles are the latest datapoint")
+
+	// b has no sum yet, so it isn't exported
+	v.part("SampleCount").with("b").(accumulator).add(1, ts)
+
+	got := collect(v)
+	if !assert.Len(t, got, 1) {
+		return
+	}
+	s := got[0].Summary
+	assert.Equal(t, uint64(14), s.GetSampleCount())
+	assert.Equal(t, 4.0, s.GetSampleSum())
+	quantiles := map[float64]float64{}
+	for _, q := range s.Quantile {
+		quantiles[q.GetQuantile()] = q.GetValue()
+	}
+	assert.Equal(t, map[float64]float64{0.99: 0.75, 0.999: 0.9}, quantiles)
+	assert.Equal(t, ts.UnixNano()/int64(time.Millisecond), got[0].GetTimestampMs())
+
+	assert.True(t, v.part("p99").DeleteLabelValues("a"))
+	assert.False(t, v.part("Sum").DeleteLabelValues("a"), "deleting a part deletes the series")
+}
+
+func TestSummaryExportConfig(t *testing.T) {
+	e := ExportConfig{
+		Namespace:  "AWS/ApplicationELB",
+		Name:       "TestSummaryExportConfig",
+		Dimensions: []string{"LoadBalancer"},
+		Statistics: []string{"Average", "SampleCount", "Sum", "p50", "p99"},
+		Summary:    true,
+	}
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, "aws_applicationelb_test_summary_export_config_average", e.String(0))
+	assert.Equal(t, "aws_applicationelb_test_summary_export_config", e.String(1))
+
+	metric := &cloudwatch.Metric{
+		Namespace:  aws.String("AWS/ApplicationELB"),
+		MetricName: aws.String("TestSummaryExportConfig"),
+		Dimensions: []*cloudwatch.Dimension{{Name: aws.String("LoadBalancer"), Value: aws.String("app/web")}},
+	}
+	clients := []Client{{Region: "us-east-1", CloudWatch: stubCloudWatch{metrics: []*cloudwatch.Metric{metric}}}}
+	ms, err := metricsToRead([]ExportConfig{e}, clients)
+	assert.NoError(t, err)
+	now := stubTimestamp.Add(2 * time.Minute)
+	assert.NoError(t, ReadMetrics(clients, now, unrollMetrics(ms), 1))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(e.collectors[0]))
+	got := collect(e.collectors[1])
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, uint64(1), got[0].Summary.GetSampleCount())
+		assert.Equal(t, 1.0, got[0].Summary.GetSampleSum())
+		assert.Len(t, got[0].Summary.Quantile, 2)
+	}
+
+	// reading the same datapoint again doesn't add it again
+	assert.NoError(t, ReadMetrics(clients, now.Add(time.Minute), unrollMetrics(ms), 1))
+	got = collect(e.collectors[1])
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, uint64(1), got[0].Summary.GetSampleCount())
+		assert.Equal(t, 1.0, got[0].Summary.GetSampleSum())
+	}
+
+	noSum := ExportConfig{Namespace: "AWS/X", Name: "Y", Statistics: []string{"SampleCount", "p99"}, Summary: true}
+	assert.EqualError(t, noSum.Validate(), "Summary requires the SampleCount and Sum statistics")
+
+	both := ExportConfig{
+		Namespace:     "AWS/X",
+		Name:          "Y",
+		Statistics:    []string{"SampleCount", "Sum"},
+		Summary:       true,
+		QuantileLabel: true,
+	}
+	assert.EqualError(t, both.Validate(), "QuantileLabel can't be used with Summary")
+
+	incomplete := ExportConfig{
+		Namespace:         "AWS/X",
+		Name:              "Y",
+		Statistics:        []string{"SampleCount", "Sum"},
+		Summary:           true,
+		IncludeIncomplete: true,
+	}
+	assert.EqualError(t, incomplete.Validate(), "Summary can't be used with IncludeIncomplete")
+}
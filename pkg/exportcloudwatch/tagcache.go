@@ -0,0 +1,88 @@
+package exportcloudwatch
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+)
+
+// cachedTagging is a Tagging that remembers the resources it reads for a
+// while, since tags change much less often than metrics are listed
+type cachedTagging struct {
+	tagging Tagging
+	ttl     time.Duration
+
+	// now is replaced by tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	resources map[string]cachedResources
+}
+
+// cachedResources are all the resources of some types, as a single page, and
+// when they expire
+type cachedResources struct {
+	gro     *resourcegroupstaggingapi.GetResourcesOutput
+	expires time.Time
+}
+
+// NewCachedTagging returns a Tagging that reads resources with t, reusing
+// what was read for up to ttl.  The first page of each request reads every
+// page, and returns them as one; failed calls aren't cached.
+func NewCachedTagging(t Tagging, ttl time.Duration) Tagging {
+	return &cachedTagging{
+		tagging:   t,
+		ttl:       ttl,
+		now:       time.Now,
+		resources: make(map[string]cachedResources),
+	}
+}
+
+func (ct *cachedTagging) GetResources(gri *resourcegroupstaggingapi.GetResourcesInput) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	// the cached results have no further pages, so a pagination token isn't
+	// one of ours
+	if aws.StringValue(gri.PaginationToken) != "" {
+		return ct.tagging.GetResources(gri)
+	}
+
+	key := strings.Join(aws.StringValueSlice(gri.ResourceTypeFilters), ",")
+	now := ct.now()
+
+	ct.mu.Lock()
+	r, ok := ct.resources[key]
+	ct.mu.Unlock()
+	if ok && now.Before(r.expires) {
+		return r.gro, nil
+	}
+
+	// pagination tokens expire, so a partial result can't be resumed and
+	// only the complete one is cached
+	all := &resourcegroupstaggingapi.GetResourcesOutput{}
+	page := *gri
+	for {
+		gro, err := ct.tagging.GetResources(&page)
+		if err != nil {
+			return nil, err
+		}
+		all.ResourceTagMappingList = append(all.ResourceTagMappingList, gro.ResourceTagMappingList...)
+
+		if aws.StringValue(gro.PaginationToken) == "" {
+			break
+		}
+		page.PaginationToken = gro.PaginationToken
+	}
+
+	ct.mu.Lock()
+	for k, r := range ct.resources {
+		if !now.Before(r.expires) {
+			delete(ct.resources, k)
+		}
+	}
+	ct.resources[key] = cachedResources{gro: all, expires: now.Add(ct.ttl)}
+	ct.mu.Unlock()
+
+	return all, nil
+}
@@ -0,0 +1,85 @@
+package exportcloudwatch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedTagging(t *testing.T) {
+	st := &stubTagging{
+		resources: map[string][]*resourcegroupstaggingapi.ResourceTagMapping{
+			"sqs": {
+				tagMapping("arn:aws:sqs:us-east-1:111111111111:jobs", map[string]string{"env": "prod"}),
+				tagMapping("arn:aws:sqs:us-east-1:111111111111:mail", map[string]string{"env": "dev"}),
+			},
+			"dynamodb:table": {
+				tagMapping("arn:aws:dynamodb:us-east-1:111111111111:table/users", map[string]string{"env": "prod"}),
+			},
+		},
+		calls: map[string]int{},
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ct := NewCachedTagging(st, 30*time.Minute).(*cachedTagging)
+	ct.now = func() time.Time { return now }
+
+	c := Client{Region: "us-east-1", AccountID: "111111111111", Tagging: ct}
+	sqs := resourceKind{namespace: "AWS/SQS", dimension: "QueueName", resourceType: "sqs"}
+	dynamodb := resourceKind{namespace: "AWS/DynamoDB", dimension: "TableName", resourceType: "dynamodb:table", prefix: "table/"}
+
+	r, err := getResources(c, sqs)
+	assert.NoError(t, err)
+	assert.Len(t, r, 2)
+	_, err = getResources(c, dynamodb)
+	assert.NoError(t, err)
+
+	now = now.Add(29 * time.Minute)
+	cached, err := getResources(c, sqs)
+	assert.NoError(t, err)
+	assert.Equal(t, r, cached)
+	assert.Equal(t, map[string]int{"sqs": 1, "dynamodb:table": 1}, st.calls, "resources are cached by type")
+
+	now = now.Add(time.Minute)
+	_, err = getResources(c, sqs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.calls["sqs"], "expired resources are read again")
+	assert.Len(t, ct.resources, 1, "expired resources are dropped")
+
+	// failures aren't cached
+	now = now.Add(time.Hour)
+	st.err = errors.New("Throttling")
+	_, err = getResources(c, sqs)
+	assert.Error(t, err)
+	st.err = nil
+	_, err = ct.GetResources(&resourcegroupstaggingapi.GetResourcesInput{ResourceTypeFilters: aws.StringSlice([]string{"sqs"})})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, st.calls["sqs"])
+
+	// nor are the pages read before a later one failed
+	now = now.Add(time.Hour)
+	ct.tagging = laterPagesFail{st}
+	_, err = getResources(c, sqs)
+	assert.Error(t, err)
+	ct.tagging = st
+	r, err = getResources(c, sqs)
+	assert.NoError(t, err)
+	assert.Len(t, r, 2)
+	assert.Equal(t, 5, st.calls["sqs"])
+}
+
+// laterPagesFail fails to read any page after the first
+type laterPagesFail struct {
+	Tagging
+}
+
+func (t laterPagesFail) GetResources(gri *resourcegroupstaggingapi.GetResourcesInput) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	if aws.StringValue(gri.PaginationToken) != "" {
+		return nil, errors.New("InvalidParameterException: expired pagination token")
+	}
+
+	return t.Tagging.GetResources(gri)
+}
@@ -0,0 +1,289 @@
+package exportcloudwatch
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Tagging is the subset of *resourcegroupstaggingapi.ResourceGroupsTaggingAPI
+// used by this package
+type Tagging interface {
+	GetResources(*resourcegroupstaggingapi.GetResourcesInput) (*resourcegroupstaggingapi.GetResourcesOutput, error)
+}
+
+// resourceKind describes how the resources named by a dimension of a
+// namespace are found with the tagging API
+type resourceKind struct {
+	namespace, dimension string
+
+	// resourceType filters GetResources, and the dimension value is the
+	// resource part of the ARN after prefix
+	resourceType, prefix string
+}
+
+// resourceKinds are the dimensions that name taggable resources; when more
+// than one of a namespace is in the Dimensions of an ExportConfig the first
+// is used
+var resourceKinds = []resourceKind{
+	{"AWS/SQS", "QueueName", "sqs", ""},
+	{"AWS/SNS", "TopicName", "sns", ""},
+	{"AWS/RDS", "DBInstanceIdentifier", "rds:db", "db:"},
+	{"AWS/RDS", "DBClusterIdentifier", "rds:cluster", "cluster:"},
+	{"AWS/ApplicationELB", "LoadBalancer", "elasticloadbalancing:loadbalancer", "loadbalancer/"},
+	{"AWS/ApplicationELB", "TargetGroup", "elasticloadbalancing:targetgroup", ""},
+	{"AWS/NetworkELB", "LoadBalancer", "elasticloadbalancing:loadbalancer", "loadbalancer/"},
+	{"AWS/NetworkELB", "TargetGroup", "elasticloadbalancing:targetgroup", ""},
+	{"AWS/ELB", "LoadBalancerName", "elasticloadbalancing:loadbalancer", "loadbalancer/"},
+	{"AWS/DynamoDB", "TableName", "dynamodb:table", "table/"},
+	{"AWS/Lambda", "FunctionName", "lambda:function", "function:"},
+	{"AWS/EC2", "InstanceId", "ec2:instance", "instance/"},
+	{"AWS/EBS", "VolumeId", "ec2:volume", "volume/"},
+	{"AWS/S3", "BucketName", "s3", ""},
+	{"AWS/Kinesis", "StreamName", "kinesis:stream", "stream/"},
+	{"AWS/Firehose", "DeliveryStreamName", "firehose:deliverystream", "deliverystream/"},
+	{"AWS/ElastiCache", "CacheClusterId", "elasticache:cluster", "cluster:"},
+	{"AWS/ES", "DomainName", "es:domain", "domain/"},
+	{"AWS/States", "StateMachineArn", "states:stateMachine", ""},
+}
+
+// resourceKindOf returns the kind of the resources of e's metrics
+func (e *ExportConfig) resourceKindOf() (resourceKind, bool) {
+	for _, k := range resourceKinds {
+		if k.namespace == e.Namespace && contains(e.Dimensions, k.dimension) {
+			return k, true
+		}
+	}
+
+	return resourceKind{}, false
+}
+
+// taggedResource is a resource and its tags
+type taggedResource struct {
+	arn  string
+	tags map[string]string
+}
+
+// name returns the dimension value that names the resource, or false if the
+// ARN isn't of kind k
+func (k resourceKind) name(arn string) (string, bool) {
+	// arn:partition:service:region:account-id:resource
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return "", false
+	}
+
+	// the dimension of a step function is its whole ARN
+	if k.dimension == "StateMachineArn" {
+		return arn, true
+	}
+
+	if !strings.HasPrefix(parts[5], k.prefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(parts[5], k.prefix), true
+}
+
+// getResources returns the resources of kind k read with c, by the dimension
+// value that names them
+func getResources(c Client, k resourceKind) (map[string]taggedResource, error) {
+	if c.Tagging == nil {
+		return nil, errors.New("no Tagging client")
+	}
+
+	resources := make(map[string]taggedResource)
+	gri := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []*string{aws.String(k.resourceType)},
+	}
+	for {
+		gro, err := c.Tagging.GetResources(gri)
+		if err != nil {
+			return nil, errors.Wrap(err, "resourcegroupstaggingapi.GetResources")
+		}
+
+		for _, m := range gro.ResourceTagMappingList {
+			arn := aws.StringValue(m.ResourceARN)
+			name, ok := k.name(arn)
+			if !ok {
+				continue
+			}
+
+			r := taggedResource{arn: arn, tags: make(map[string]string, len(m.Tags))}
+			for _, t := range m.Tags {
+				r.tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+			}
+			resources[name] = r
+		}
+
+		if aws.StringValue(gro.PaginationToken) == "" {
+			break
+		}
+		gri.PaginationToken = gro.PaginationToken
+	}
+
+	return resources, nil
+}
+
+// resourceKey identifies the resources of a kind read with a client
+type resourceKey struct {
+	region, accountID string
+	kind              resourceKind
+}
+
+// lookedUp is the result of reading the resources of a resourceKey
+type lookedUp struct {
+	resources map[string]taggedResource
+	err       error
+}
+
+// resourceLookup reads the resources of each kind and client once per refresh
+type resourceLookup map[resourceKey]lookedUp
+
+// resources returns the resources of kind k of c by the dimension value that
+// names them
+func (l resourceLookup) resources(c Client, k resourceKind) (map[string]taggedResource, error) {
+	key := resourceKey{region: c.Region, accountID: c.AccountID, kind: k}
+	if r, ok := l[key]; ok {
+		return r.resources, r.err
+	}
+
+	r, err := getResources(c, k)
+	l[key] = lookedUp{resources: r, err: err}
+
+	return r, err
+}
+
+// tagValues returns the values of the TagLabels of e for the resource r
+func (e *ExportConfig) tagValues(r taggedResource) []string {
+	values := make([]string, len(e.tagKeys))
+	for i, k := range e.tagKeys {
+		values[i] = r.tags[k]
+	}
+
+	return values
+}
+
+// resourceInfoName is the name of the metric the tags of resources are
+// exported on
+const resourceInfoName = "aws_resource_info"
+
+var invalidLabelCharsRE = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// tagLabel returns the label of the tag key on aws_resource_info
+func tagLabel(key string) string {
+	return "tag_" + invalidLabelCharsRE.ReplaceAllString(key, "_")
+}
+
+// resourceInfoSeries is a series of aws_resource_info
+type resourceInfoSeries struct {
+	labels map[string]string
+}
+
+// resourceInfoCollector exports the tags of the resources of an ExportConfig
+// as aws_resource_info, with a tag_ label per tag.  Resources have different
+// tags, so the collector is unchecked.
+type resourceInfoCollector struct {
+	mu     sync.Mutex
+	series []resourceInfoSeries
+
+	// collided records the tags, by ARN and key, already counted in
+	// resourceInfoLabelCollisions
+	collided map[string]bool
+}
+
+var resourceInfoLabelCollisions = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "monitoring_cloudwatch_resource_info_label_collisions_total",
+	Help: "Count of tags left off aws_resource_info because their label was already used",
+})
+
+func init() {
+	prometheus.MustRegister(resourceInfoLabelCollisions)
+}
+
+func (*resourceInfoCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (ri *resourceInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	ri.mu.Lock()
+	series := ri.series
+	ri.mu.Unlock()
+
+	for _, s := range series {
+		names := make([]string, 0, len(s.labels))
+		for k := range s.labels {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		values := make([]string, len(names))
+		for i, n := range names {
+			values[i] = s.labels[n]
+		}
+
+		desc := prometheus.NewDesc(resourceInfoName, "Tags of the AWS resources of exported metrics", names, nil)
+		m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(desc, err)
+		}
+		ch <- m
+	}
+}
+
+// collide counts the collision of the tag called key, unless it was already
+// counted
+func (ri *resourceInfoCollector) collide(key string) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	if ri.collided[key] {
+		return
+	}
+	if ri.collided == nil {
+		ri.collided = make(map[string]bool)
+	}
+	ri.collided[key] = true
+	resourceInfoLabelCollisions.Inc()
+}
+
+// set replaces the exported series
+func (ri *resourceInfoCollector) set(series []resourceInfoSeries) {
+	ri.mu.Lock()
+	ri.series = series
+	ri.mu.Unlock()
+}
+
+// newSeries returns the aws_resource_info series of r, the resource named by
+// the dimension labeled label, read with c.  Tags whose labels are already
+// used, by another tag or the other labels, are left off, and counted the
+// first time they are.
+func (ri *resourceInfoCollector) newSeries(c Client, label, name string, r taggedResource) resourceInfoSeries {
+	labels := map[string]string{
+		accountLabel: c.AccountID,
+		regionLabel:  c.Region,
+		arnLabel:     r.arn,
+		label:        name,
+	}
+
+	// sort the keys so that keys with the same label always resolve the same
+	keys := make([]string, 0, len(r.tags))
+	for k := range r.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		l := tagLabel(k)
+		if _, ok := labels[l]; ok {
+			ri.collide(r.arn + "\xff" + k)
+			continue
+		}
+		labels[l] = r.tags[k]
+	}
+
+	return resourceInfoSeries{labels: labels}
+}
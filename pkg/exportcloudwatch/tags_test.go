@@ -0,0 +1,273 @@
+package exportcloudwatch
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubTagging returns resources in pages of one, counting the calls by
+// resource type
+type stubTagging struct {
+	resources map[string][]*resourcegroupstaggingapi.ResourceTagMapping
+	calls     map[string]int
+	err       error
+}
+
+func (st *stubTagging) GetResources(gri *resourcegroupstaggingapi.GetResourcesInput) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	if st.err != nil {
+		return nil, st.err
+	}
+
+	resourceType := *gri.ResourceTypeFilters[0]
+	if gri.PaginationToken == nil {
+		st.calls[resourceType]++
+	}
+
+	page := 0
+	if gri.PaginationToken != nil {
+		page = len(*gri.PaginationToken)
+	}
+
+	gro := &resourcegroupstaggingapi.GetResourcesOutput{PaginationToken: aws.String("")}
+	resources := st.resources[resourceType]
+	if page < len(resources) {
+		gro.ResourceTagMappingList = resources[page : page+1]
+	}
+	if page+1 < len(resources) {
+		gro.PaginationToken = aws.String(string(make([]byte, page+1)))
+	}
+
+	return gro, nil
+}
+
+func tagMapping(arn string, tags map[string]string) *resourcegroupstaggingapi.ResourceTagMapping {
+	m := &resourcegroupstaggingapi.ResourceTagMapping{ResourceARN: aws.String(arn)}
+	for k, v := range tags {
+		m.Tags = append(m.Tags, &resourcegroupstaggingapi.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	return m
+}
+
+func TestResourceKindName(t *testing.T) {
+	kinds := map[string]resourceKind{}
+	for _, k := range resourceKinds {
+		kinds[k.namespace+" "+k.dimension] = k
+	}
+
+	tests := []struct {
+		kind, arn, name string
+	}{
+		{"AWS/SQS QueueName", "arn:aws:sqs:us-east-1:111111111111:jobs", "jobs"},
+		{"AWS/RDS DBInstanceIdentifier", "arn:aws:rds:us-east-1:111111111111:db:main", "main"},
+		{"AWS/ApplicationELB LoadBalancer", "arn:aws:elasticloadbalancing:us-east-1:111111111111:loadbalancer/app/web/50dc6c495c0c9188", "app/web/50dc6c495c0c9188"},
+		{"AWS/ApplicationELB TargetGroup", "arn:aws:elasticloadbalancing:us-east-1:111111111111:targetgroup/web/73e2d6bc24d8a067", "targetgroup/web/73e2d6bc24d8a067"},
+		{"AWS/DynamoDB TableName", "arn:aws:dynamodb:us-east-1:111111111111:table/users", "users"},
+		{"AWS/S3 BucketName", "arn:aws:s3:::logs", "logs"},
+	}
+	for _, test := range tests {
+		name, ok := kinds[test.kind].name(test.arn)
+		assert.True(t, ok, test.arn)
+		assert.Equal(t, test.name, name)
+	}
+
+	_, ok := kinds["AWS/RDS DBInstanceIdentifier"].name("arn:aws:rds:us-east-1:111111111111:cluster:main")
+	assert.False(t, ok)
+}
+
+func TestMetricsToReadTags(t *testing.T) {
+	st := &stubTagging{
+		resources: map[string][]*resourcegroupstaggingapi.ResourceTagMapping{"sqs": {
+			tagMapping("arn:aws:sqs:us-east-1:111111111111:jobs", map[string]string{"team": "search", "cost-center": "42"}),
+			tagMapping("arn:aws:sqs:us-east-1:111111111111:mail", map[string]string{"team": "growth"}),
+		}},
+		calls: map[string]int{},
+	}
+	client := Client{
+		Region:    "us-east-1",
+		AccountID: "111111111111",
+		CloudWatch: stubCloudWatch{metrics: []*cloudwatch.Metric{
+			newMetric("AWS/SQS", "TestMetricsToReadTagsSent", "QueueName", "jobs"),
+			newMetric("AWS/SQS", "TestMetricsToReadTagsSent", "QueueName", "untagged"),
+			newMetric("AWS/SQS", "TestMetricsToReadTagsAge", "QueueName", "jobs"),
+		}},
+		Tagging: st,
+	}
+
+	sent := ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "TestMetricsToReadTagsSent",
+		Dimensions: []string{"QueueName"},
+		Statistics: []string{"Sum"},
+		TagLabels:  map[string]string{"team": "team", "cost-center": "cost_center"},
+	}
+	age := ExportConfig{
+		Namespace:    "AWS/SQS",
+		Name:         "TestMetricsToReadTagsAge",
+		Dimensions:   []string{"QueueName"},
+		Statistics:   []string{"Maximum"},
+		ResourceInfo: true,
+	}
+	assert.NoError(t, sent.Validate())
+	assert.NoError(t, age.Validate())
+	assert.Equal(t, []string{accountLabel, regionLabel, "queue_name", "cost_center", "team"}, sent.labels)
+
+	ms, err := metricsToRead([]ExportConfig{sent, age}, []Client{client})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"sqs": 1}, st.calls, "resources are read once per refresh")
+
+	var got [][]string
+	for _, m := range ms {
+		if *m.cloudwatchMetric.MetricName == "TestMetricsToReadTagsSent" {
+			got = append(got, m.labelValues)
+		}
+	}
+	assert.Equal(t, [][]string{
+		{"111111111111", "us-east-1", "jobs", "42", "search"},
+		{"111111111111", "us-east-1", "untagged", "", ""},
+	}, got)
+
+	info := collect(age.info)
+	if assert.Len(t, info, 1) {
+		labels := map[string]string{}
+		for _, l := range info[0].Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		assert.Equal(t, map[string]string{
+			"account_id":      "111111111111",
+			"region":          "us-east-1",
+			"arn":             "arn:aws:sqs:us-east-1:111111111111:jobs",
+			"queue_name":      "jobs",
+			"tag_team":        "search",
+			"tag_cost_center": "42",
+		}, labels)
+	}
+
+	// another config of the same resources doesn't export them again
+	again := age
+	again.Name = "TestMetricsToReadTagsSent"
+	again.info = nil
+	assert.NoError(t, again.Validate())
+	_, err = metricsToRead([]ExportConfig{age, again}, []Client{client})
+	assert.NoError(t, err)
+	assert.Len(t, collect(age.info), 1)
+	assert.Empty(t, collect(again.info))
+
+	// if the tags can't be read the metrics are still exported
+	st.err = errors.New("AccessDenied")
+	ms, err = metricsToRead([]ExportConfig{sent, age}, []Client{client})
+	assert.NoError(t, err)
+	assert.Len(t, ms, 3)
+	assert.Equal(t, []string{"111111111111", "us-east-1", "jobs", "", ""}, ms[0].labelValues)
+	assert.Empty(t, collect(age.info))
+}
+
+func TestNewResourceInfoSeries(t *testing.T) {
+	r := taggedResource{
+		arn: "arn:aws:sqs:us-east-1:111111111111:jobs",
+		tags: map[string]string{
+			"arn":       "spoofed",
+			"queue":     "spoofed",
+			"team-name": "search",
+			"team_name": "growth",
+		},
+	}
+
+	ri := &resourceInfoCollector{}
+	c := Client{Region: "us-east-1", AccountID: "111111111111"}
+	collisions := testutil.ToFloat64(resourceInfoLabelCollisions)
+	s := ri.newSeries(c, "tag_queue", "jobs", r)
+	assert.Equal(t, map[string]string{
+		"account_id":    "111111111111",
+		"region":        "us-east-1",
+		"arn":           "arn:aws:sqs:us-east-1:111111111111:jobs",
+		"tag_queue":     "jobs",
+		"tag_arn":       "spoofed",
+		"tag_team_name": "search",
+	}, s.labels)
+	assert.Equal(t, collisions+2, testutil.ToFloat64(resourceInfoLabelCollisions))
+
+	// the same collisions aren't counted again when the tags are read again
+	ri.newSeries(c, "tag_queue", "jobs", r)
+	assert.Equal(t, collisions+2, testutil.ToFloat64(resourceInfoLabelCollisions))
+}
+
+func TestMetricsToReadTagFilters(t *testing.T) {
+	st := &stubTagging{
+		resources: map[string][]*resourcegroupstaggingapi.ResourceTagMapping{"sqs": {
+			tagMapping("arn:aws:sqs:us-east-1:111111111111:jobs", map[string]string{"env": "prod"}),
+			tagMapping("arn:aws:sqs:us-east-1:111111111111:jobs-canary", map[string]string{"env": "prod", "canary": "true"}),
+			tagMapping("arn:aws:sqs:us-east-1:111111111111:mail", map[string]string{"env": "dev"}),
+		}},
+		calls: map[string]int{},
+	}
+	client := Client{
+		Region:    "us-east-1",
+		AccountID: "111111111111",
+		CloudWatch: stubCloudWatch{metrics: []*cloudwatch.Metric{
+			newMetric("AWS/SQS", "TestMetricsToReadTagFilters", "QueueName", "jobs"),
+			newMetric("AWS/SQS", "TestMetricsToReadTagFilters", "QueueName", "jobs-canary"),
+			newMetric("AWS/SQS", "TestMetricsToReadTagFilters", "QueueName", "mail"),
+			newMetric("AWS/SQS", "TestMetricsToReadTagFilters", "QueueName", "untagged"),
+		}},
+		Tagging: st,
+	}
+
+	e := ExportConfig{
+		Namespace:   "AWS/SQS",
+		Name:        "TestMetricsToReadTagFilters",
+		Dimensions:  []string{"QueueName"},
+		Statistics:  []string{"Sum"},
+		TagsMatch:   map[string]*regexp.Regexp{"env": regexp.MustCompile("^prod$")},
+		TagsNoMatch: map[string]*regexp.Regexp{"canary": regexp.MustCompile("^true$")},
+	}
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, []string{accountLabel, regionLabel, "queue_name"}, e.labels, "filters add no labels")
+
+	ms, err := metricsToRead([]ExportConfig{e}, []Client{client})
+	assert.NoError(t, err)
+	if assert.Len(t, ms, 1) {
+		assert.Equal(t, []string{"111111111111", "us-east-1", "jobs"}, ms[0].labelValues)
+	}
+
+	// a missing tag is empty
+	e.TagsMatch = nil
+	e.TagsNoMatch = map[string]*regexp.Regexp{"env": regexp.MustCompile("^$")}
+	ms, err = metricsToRead([]ExportConfig{e}, []Client{client})
+	assert.NoError(t, err)
+	assert.Len(t, ms, 3)
+
+	// without tags the metrics can't be filtered, so the refresh fails
+	st.err = errors.New("AccessDenied")
+	_, err = metricsToRead([]ExportConfig{e}, []Client{client})
+	assert.EqualError(t, err, "region=us-east-1: resourcegroupstaggingapi.GetResources: AccessDenied")
+}
+
+func TestTagsValidate(t *testing.T) {
+	e := ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "NumberOfMessagesSent",
+		Statistics: []string{"Sum"},
+		TagLabels:  map[string]string{"team": "team"},
+	}
+	assert.EqualError(t, e.Validate(), "No taggable resource in the Dimensions of Namespace AWS/SQS")
+
+	e.Dimensions = []string{"QueueName"}
+	e.TagLabels = map[string]string{"name": "queue_name"}
+	assert.EqualError(t, e.Validate(), "Label queue_name is used more than once")
+
+	e = ExportConfig{
+		Namespace:  "AWS/SQS",
+		Name:       "NumberOfMessagesSent",
+		Statistics: []string{"Sum"},
+		TagsMatch:  map[string]*regexp.Regexp{"env": regexp.MustCompile("^prod$")},
+	}
+	assert.EqualError(t, e.Validate(), "No taggable resource in the Dimensions of Namespace AWS/SQS")
+}
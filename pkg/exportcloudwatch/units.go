@@ -0,0 +1,69 @@
+package exportcloudwatch
+
+import "strings"
+
+// baseUnit is how to convert a CloudWatch unit to a prometheus base unit
+type baseUnit struct {
+	// scale converts a value to the base unit, and suffix is the conventional
+	// suffix of names in the base unit
+	scale  float64
+	suffix string
+}
+
+const (
+	kibi = 1024
+	mebi = kibi * 1024
+	gibi = mebi * 1024
+	tebi = gibi * 1024
+)
+
+// baseUnits maps the units of CloudWatch to prometheus base units
+var baseUnits = map[string]baseUnit{
+	"Seconds":      {1, "_seconds"},
+	"Microseconds": {1e-6, "_seconds"},
+	"Milliseconds": {1e-3, "_seconds"},
+
+	"Bytes":     {1, "_bytes"},
+	"Kilobytes": {kibi, "_bytes"},
+	"Megabytes": {mebi, "_bytes"},
+	"Gigabytes": {gibi, "_bytes"},
+	"Terabytes": {tebi, "_bytes"},
+	"Bits":      {1.0 / 8, "_bytes"},
+	"Kilobits":  {kibi / 8, "_bytes"},
+	"Megabits":  {mebi / 8, "_bytes"},
+	"Gigabits":  {gibi / 8, "_bytes"},
+	"Terabits":  {tebi / 8, "_bytes"},
+
+	"Percent": {0.01, "_ratio"},
+	"Count":   {1, ""},
+	"None":    {1, ""},
+}
+
+func init() {
+	// the rates are per second of the units above
+	for _, u := range []string{
+		"Bytes", "Kilobytes", "Megabytes", "Gigabytes", "Terabytes",
+		"Bits", "Kilobits", "Megabits", "Gigabits", "Terabits",
+		"Count",
+	} {
+		b := baseUnits[u]
+		b.suffix += "_per_second"
+		baseUnits[u+"/Second"] = b
+	}
+}
+
+// statisticHasUnit returns true if the values of statistic s are in the unit
+// of the metric, rather than counts of datapoints
+func statisticHasUnit(s string) bool {
+	if s == "SampleCount" {
+		return false
+	}
+
+	for _, prefix := range []string{"tc", "TC(", "PR("} {
+		if strings.HasPrefix(s, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package exportcloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitNames(t *testing.T) {
+	e := ExportConfig{
+		Namespace:  "AWS/ApplicationELB",
+		Name:       "TestUnitNames",
+		Statistics: []string{"Average", "SampleCount", "p99", "PR(:0.3)"},
+		Unit:       "Milliseconds",
+	}
+	assert.NoError(t, e.Validate())
+
+	assert.Equal(t, "aws_applicationelb_test_unit_names_average_seconds", e.String(0))
+	assert.Equal(t, "aws_applicationelb_test_unit_names_sample_count", e.String(1))
+	assert.Equal(t, "aws_applicationelb_test_unit_names_p99_seconds", e.String(2))
+	assert.Equal(t, "aws_applicationelb_test_unit_names_pr_to_0_3", e.String(3))
+	assert.Equal(t, []float64{1e-3, 1, 1e-3, 1}, []float64{e.scale(0), e.scale(1), e.scale(2), e.scale(3)})
+
+	e.Unit = "Bytes/Second"
+	assert.Equal(t, "aws_applicationelb_test_unit_names_average_bytes_per_second", e.String(0))
+
+	summary := ExportConfig{
+		Namespace:  "AWS/ApplicationELB",
+		Name:       "TestUnitNamesSummary",
+		Statistics: []string{"SampleCount", "Sum", "p99"},
+		Summary:    true,
+		Unit:       "Seconds",
+	}
+	assert.NoError(t, summary.Validate())
+	assert.Equal(t, "aws_applicationelb_test_unit_names_summary_seconds", summary.String(0))
+
+	invalid := ExportConfig{Namespace: "AWS/X", Name: "Y", Statistics: []string{"Sum"}, Unit: "Fortnights"}
+	assert.EqualError(t, invalid.Validate(), "Invalid Unit Fortnights")
+}
+
+func TestReadMetricsUnits(t *testing.T) {
+	rcw := &recordingCloudWatch{}
+	metric := &cloudwatch.Metric{Namespace: aws.String("AWS/EBS"), MetricName: aws.String("VolumeReadBytes")}
+
+	kb, count := &mockGauge{}, &mockGauge{}
+	metricstats := map[string]MetricStat{
+		"i0": {statistic: "Sum", cloudwatchMetric: metric, gauge: kb, unit: "Kilobytes", scale: 1024},
+		"i1": {statistic: "SampleCount", cloudwatchMetric: metric, gauge: count, unit: "Kilobytes", scale: 1},
+	}
+
+	var queries []*cloudwatch.MetricDataQuery
+	for id, ms := range metricstats {
+		queries = append(queries, ms.queries(id, time.Minute)...)
+	}
+	for _, q := range queries {
+		assert.Equal(t, "Kilobytes", *q.MetricStat.Unit)
+	}
+
+	assert.NoError(t, ReadMetrics([]Client{{CloudWatch: rcw}}, time.Now(), metricstats, 1))
+	assert.Equal(t, 1024.0, *kb.value)
+	assert.Equal(t, 1.0, *count.value)
+}
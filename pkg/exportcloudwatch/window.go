@@ -0,0 +1,79 @@
+package exportcloudwatch
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPeriod is the Period of an ExportConfig that doesn't set one
+const DefaultPeriod = time.Minute
+
+// window is the time range a metric is read over
+type window struct {
+	// period is the length of each datapoint, delay how far before now the
+	// window ends, and rng the length of the window
+	period, delay, rng time.Duration
+
+	// delaySet is true if delay was set, since a delay of 0 is allowed
+	delaySet bool
+
+	// includeIncomplete reads the datapoint of the period in progress at the
+	// end of the window
+	includeIncomplete bool
+}
+
+// newWindow returns the window of a config; a nil delay is one period
+func newWindow(period time.Duration, delay *time.Duration, rng time.Duration, includeIncomplete bool) window {
+	w := window{period: period, rng: rng, includeIncomplete: includeIncomplete}
+	if delay != nil {
+		w.delay, w.delaySet = *delay, true
+	}
+
+	return w
+}
+
+// withDefaults returns w with unset fields replaced by their defaults: a period
+// of DefaultPeriod, a delay of one period and a range of five periods.
+func (w window) withDefaults() window {
+	if w.period == 0 {
+		w.period = DefaultPeriod
+	}
+	if !w.delaySet {
+		w.delay, w.delaySet = w.period, true
+	}
+	if w.rng == 0 {
+		w.rng = 5 * w.period
+	}
+
+	return w
+}
+
+// bounds returns the start and end of the window when read at now.  The end is
+// rounded up to the period, so the window includes the period in progress at
+// now minus the delay.
+func (w window) bounds(now time.Time) (start, end time.Time) {
+	end = now.Add(-w.delay)
+	if truncated := end.Truncate(w.period); !truncated.Equal(end) {
+		end = truncated.Add(w.period)
+	}
+
+	return end.Add(-w.rng), end
+}
+
+func (w window) validate() error {
+	switch {
+	case w.period < 0 || w.delay < 0 || w.rng < 0:
+		return errors.New("Period, Delay and Range must not be negative")
+	case w.period%time.Minute != 0 && w.period != time.Second && w.period != 5*time.Second &&
+		w.period != 10*time.Second && w.period != 30*time.Second:
+		return errors.New("Period must be 1, 5, 10, 30 or a multiple of 60 seconds")
+	}
+
+	w = w.withDefaults()
+	if w.rng < w.period {
+		return errors.New("Range must be at least one Period")
+	}
+
+	return nil
+}
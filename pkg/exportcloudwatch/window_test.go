@@ -0,0 +1,91 @@
+package exportcloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type windowTest struct {
+	name string
+
+	window
+
+	err        string
+	start, end time.Time
+}
+
+func TestWindow(t *testing.T) {
+	now := time.Date(2020, 8, 1, 12, 34, 56, 0, time.UTC)
+	tests := []windowTest{
+		{
+			name:  "default",
+			start: time.Date(2020, 8, 1, 12, 29, 0, 0, time.UTC),
+			end:   time.Date(2020, 8, 1, 12, 34, 0, 0, time.UTC),
+		},
+		{
+			name:   "five minutes",
+			window: window{period: 5 * time.Minute, delay: 10 * time.Minute, delaySet: true, rng: 15 * time.Minute},
+			start:  time.Date(2020, 8, 1, 12, 10, 0, 0, time.UTC),
+			end:    time.Date(2020, 8, 1, 12, 25, 0, 0, time.UTC),
+		},
+		{
+			name:   "high resolution",
+			window: window{period: 10 * time.Second, delay: time.Second, delaySet: true},
+			start:  time.Date(2020, 8, 1, 12, 34, 10, 0, time.UTC),
+			end:    time.Date(2020, 8, 1, 12, 35, 0, 0, time.UTC),
+		},
+		{
+			name:   "aligned",
+			window: window{delay: 4*time.Minute + 56*time.Second, delaySet: true},
+			start:  time.Date(2020, 8, 1, 12, 25, 0, 0, time.UTC),
+			end:    time.Date(2020, 8, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "no delay",
+			window: window{delaySet: true},
+			start:  time.Date(2020, 8, 1, 12, 30, 0, 0, time.UTC),
+			end:    time.Date(2020, 8, 1, 12, 35, 0, 0, time.UTC),
+		},
+		{
+			name:   "bad period",
+			window: window{period: 90 * time.Second},
+			err:    "Period must be 1, 5, 10, 30 or a multiple of 60 seconds",
+		},
+		{
+			name:   "short range",
+			window: window{period: 5 * time.Minute, rng: time.Minute},
+			err:    "Range must be at least one Period",
+		},
+		{
+			name:   "negative",
+			window: window{delay: -time.Minute, delaySet: true},
+			err:    "Period, Delay and Range must not be negative",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.window.validate()
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			start, end := test.window.withDefaults().bounds(now)
+			assert.Equal(t, test.start, start)
+			assert.Equal(t, test.end, end)
+		})
+	}
+}
+
+func TestNewWindow(t *testing.T) {
+	assert.Equal(t, time.Minute, newWindow(time.Minute, nil, 0, false).withDefaults().delay)
+
+	zero := time.Duration(0)
+	w := newWindow(time.Minute, &zero, 0, false)
+	assert.NoError(t, w.validate())
+	assert.Equal(t, time.Duration(0), w.withDefaults().delay, "a delay of 0 is kept")
+}
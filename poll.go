@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZipRecruiter/cloudwatching/pkg/exportcloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lastPollSuccess is the time of the last successful poll in unix nanoseconds
+var lastPollSuccess int64
+
+// processStart is when staleness is measured from until a poll succeeds
+var processStart = time.Now()
+
+var pollLastSuccess = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "monitoring_cloudwatch_poll_last_success_timestamp_seconds",
+	Help: "Time of the last successful poll of CloudWatch",
+}, func() float64 {
+	return float64(atomic.LoadInt64(&lastPollSuccess)) / float64(time.Second)
+})
+
+var pollStaleness = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "monitoring_cloudwatch_poll_staleness_seconds",
+	Help: "Time since the last successful poll of CloudWatch, or since startup if none has succeeded",
+}, func() float64 {
+	last := atomic.LoadInt64(&lastPollSuccess)
+	if last == 0 {
+		return time.Since(processStart).Seconds()
+	}
+
+	return time.Since(time.Unix(0, last)).Seconds()
+})
+
+var pollSeconds = prometheus.NewSummary(prometheus.SummaryOpts{
+	Name: "monitoring_cloudwatch_poll_seconds",
+	Help: "Duration of polls of CloudWatch",
+})
+
+func init() {
+	prometheus.MustRegister(pollLastSuccess, pollStaleness, pollSeconds)
+}
+
+// poll calls read every interval, starting immediately, until stop is closed.
+func poll(interval time.Duration, read func() error, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		start := time.Now()
+		if err := read(); err != nil {
+			log.Print(err)
+		} else {
+			atomic.StoreInt64(&lastPollSuccess, time.Now().UnixNano())
+		}
+		pollSeconds.Observe(time.Now().Sub(start).Seconds())
+
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// readMetrics reads the current values of metrics from CloudWatch, reading up
+// to concurrency batches at a time.
+func readMetrics(clients []exportcloudwatch.Client, concurrency int) error {
+	return exportcloudwatch.ReadMetrics(clients, time.Now(), metrics.Load(), concurrency)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoll(t *testing.T) {
+	atomic.StoreInt64(&lastPollSuccess, 0)
+
+	// until a poll succeeds, staleness grows from when the exporter started
+	defer func(orig time.Time) { processStart = orig }(processStart)
+	processStart = time.Now().Add(-time.Minute)
+	assert.InDelta(t, 60, testutil.ToFloat64(pollStaleness), 1)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	reads := make(chan struct{}, 10)
+
+	var calls int
+	read := func() error {
+		calls++
+		reads <- struct{}{}
+		if calls == 1 {
+			return errors.New("throttled")
+		}
+		if calls == 3 {
+			close(stop)
+		}
+		return nil
+	}
+
+	go func() {
+		poll(time.Millisecond, read, stop)
+		close(done)
+	}()
+
+	<-reads
+	<-reads
+	<-reads
+	<-done
+
+	assert.Equal(t, 3, calls)
+	last := atomic.LoadInt64(&lastPollSuccess)
+	assert.NotZero(t, last, "successful poll was recorded")
+	assert.WithinDuration(t, time.Now(), time.Unix(0, last), time.Second)
+	assert.Less(t, testutil.ToFloat64(pollStaleness), 1.0)
+}
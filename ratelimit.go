@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// tokenBucket limits calls to rate per second, allowing bursts of up to one
+// second's worth of calls.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// now and sleep are replaced by tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Wait blocks until the caller may make a call.
+func (b *tokenBucket) Wait() {
+	b.mu.Lock()
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	// taking the token before sleeping reserves it, so concurrent callers queue
+	// up behind each other
+	b.tokens--
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if wait > 0 {
+		b.sleep(wait)
+	}
+}
+
+// rateLimitHandler returns a handler that limits each of the named operations
+// to its rate per second.  It is meant for the Sign handlers of a client so
+// that retries are limited too.
+func rateLimitHandler(limits map[string]float64) request.NamedHandler {
+	buckets := make(map[string]*tokenBucket, len(limits))
+	for op, rate := range limits {
+		buckets[op] = newTokenBucket(rate)
+	}
+
+	return request.NamedHandler{
+		Name: "RateLimit",
+		Fn: func(r *request.Request) {
+			if b := buckets[r.Operation.Name]; b != nil {
+				b.Wait()
+			}
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock that only moves when slept on
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.slept += d
+}
+
+func newFakeBucket(rate float64) (*tokenBucket, *fakeClock) {
+	c := &fakeClock{now: time.Unix(1600000000, 0)}
+	b := newTokenBucket(rate)
+	b.now = c.Now
+	b.sleep = c.Sleep
+
+	return b, c
+}
+
+func TestTokenBucket(t *testing.T) {
+	b, c := newFakeBucket(10)
+
+	for i := 0; i < 10; i++ {
+		b.Wait()
+	}
+	assert.Equal(t, time.Duration(0), c.slept, "a second's worth of calls are allowed at once")
+
+	for i := 0; i < 5; i++ {
+		b.Wait()
+	}
+	assert.Equal(t, 500*time.Millisecond, c.slept, "further calls are spaced out")
+
+	c.now = c.now.Add(time.Hour)
+	c.slept = 0
+	for i := 0; i < 11; i++ {
+		b.Wait()
+	}
+	assert.Equal(t, 100*time.Millisecond, c.slept, "idle time doesn't accumulate past the burst")
+}
+
+func TestTokenBucketSlow(t *testing.T) {
+	b, c := newFakeBucket(0.5)
+
+	b.Wait()
+	b.Wait()
+	assert.Equal(t, 2*time.Second, c.slept)
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	h := rateLimitHandler(map[string]float64{"ListMetrics": 20})
+	call := func(op string, n int) time.Duration {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			h.Fn(&request.Request{Operation: &request.Operation{Name: op}})
+		}
+		return time.Since(start)
+	}
+
+	assert.True(t, call("GetMetricData", 100) < 50*time.Millisecond, "unlimited operations don't wait")
+	assert.True(t, call("ListMetrics", 22) >= 90*time.Millisecond, "limited operations wait")
+}